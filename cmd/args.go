@@ -0,0 +1,281 @@
+package commander
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/secrets"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Arg types.
+const (
+	ArgString = "string"
+	ArgInt    = "int"
+	ArgBool   = "bool"
+	ArgEnum   = "enum"
+)
+
+// Arg is an arg a flow takes, passed as name=value. It is written either as its name only, or as a map:
+//
+//	args:
+//	  - token
+//	  - name: arch
+//	    type: enum
+//	    values: [armv7, amd64]
+//	    default: armv7
+//	  - name: token
+//	    secret: true
+//	    env: GITHUB_TOKEN
+//
+// On the command line, the value of a secret arg can be passed as @path to read it from a file, like
+// token=@/run/secrets/gh, see BuildTool.ArgFiles.
+// A secret arg that isn't passed, nor set in its env, is looked up by its name in the secrets store.
+type Arg struct {
+	Name     string   `yaml:"name"`
+	Help     string   `yaml:"help"`
+	Required bool     `yaml:"required"` // the flow fails before any step runs when the arg is missing
+	Default  string   `yaml:"default"`  // the value when the arg is not passed
+	Type     string   `yaml:"type"`     // string (default), int, bool or enum
+	Values   []string `yaml:"values"`   // the values of an enum
+	Pattern  string   `yaml:"pattern"`  // a regexp the whole value must match
+	Secret   bool     `yaml:"secret"`   // the value is redacted from all output, see redactor
+	Env      string   `yaml:"env"`      // the environment variable the value is taken from when the arg is not passed
+}
+
+// UnmarshalYAML accepts `- token` as well as `- name: token`.
+func (a *Arg) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*a = Arg{Name: value.Value}
+		return nil
+	}
+	type plain Arg
+	return value.Decode((*plain)(a))
+}
+
+// check returns an error if the definition of the arg is invalid.
+func (a Arg) check() error {
+	if a.Name == "" {
+		return fmt.Errorf("an arg has no name")
+	}
+	switch a.Type {
+	case "", ArgString, ArgInt, ArgBool:
+	case ArgEnum:
+		if len(a.Values) == 0 {
+			return fmt.Errorf("arg %s is an enum without values", a.Name)
+		}
+	default:
+		return fmt.Errorf("arg %s has an unknown type %q, try: string, int, bool or enum", a.Name, a.Type)
+	}
+	if a.Pattern != "" {
+		if _, err := regexp.Compile(a.Pattern); err != nil {
+			return fmt.Errorf("arg %s has an invalid pattern: %v", a.Name, err)
+		}
+	}
+	if a.Default != "" {
+		if err := a.checkValue(a.Default); err != nil {
+			return fmt.Errorf("the default of %v", err)
+		}
+	}
+	return nil
+}
+
+// checkValue returns an error if the value isn't valid for the arg.
+func (a Arg) checkValue(value string) error {
+	shown := strconv.Quote(value)
+	if a.Secret {
+		shown = "the value"
+	}
+	switch a.Type {
+	case ArgInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("arg %s must be an int, not %s", a.Name, shown)
+		}
+	case ArgBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("arg %s must be true or false, not %s", a.Name, shown)
+		}
+	case ArgEnum:
+		if !contains(a.Values, value) {
+			return fmt.Errorf("arg %s must be one of %s, not %s", a.Name, strings.Join(a.Values, ", "), shown)
+		}
+	}
+	if a.Pattern != "" && !regexp.MustCompile("^(?:"+a.Pattern+")$").MatchString(value) {
+		return fmt.Errorf("arg %s must match %s, %s does not", a.Name, a.Pattern, shown)
+	}
+	return nil
+}
+
+// checkArgs returns an error if any arg of the flow is invalid.
+func (b *BuildYAML) checkArgs() error {
+	for _, arg := range b.Args {
+		if err := arg.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// argNames returns the names of the args of the flow.
+func (b *BuildYAML) argNames() []string {
+	names := make([]string, 0, len(b.Args))
+	for _, arg := range b.Args {
+		names = append(names, arg.Name)
+	}
+	return names
+}
+
+// resolveArgs checks the args passed to the flow against its args: section, and returns them with the values
+// of the args that weren't passed taken from their env, the secrets store or their default. Secret args passed
+// as @path are read from the file with argFiles, and rejected without. Every missing or invalid arg is
+// reported in the error.
+func (b *BuildYAML) resolveArgs(args map[string]string, store secrets.Store, argFiles bool) (map[string]string, error) {
+	resolved := make(map[string]string, len(args))
+	for key, val := range args {
+		resolved[key] = val
+	}
+	var errs []string
+	for _, arg := range b.Args {
+		val, ok, err := arg.lookup(args, store, argFiles)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !ok {
+			if arg.Required {
+				errs = append(errs, fmt.Sprintf("missing required arg %s", arg.Name))
+				continue
+			}
+			if arg.Default == "" {
+				continue
+			}
+			val = arg.Default
+		}
+		resolved[arg.Name] = val
+		if err := arg.checkValue(val); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid args: %s", strings.Join(errs, "; "))
+	}
+	return resolved, nil
+}
+
+// lookup returns the value of the arg from the args passed to the flow, reading a secret passed as @path from
+// the file with argFiles, or else from its env, or else from the secrets store for a secret. It reports false
+// when none has it.
+func (a Arg) lookup(args map[string]string, store secrets.Store, argFiles bool) (string, bool, error) {
+	if val, ok := args[a.Name]; ok {
+		if a.Secret && strings.HasPrefix(val, "@") {
+			if !argFiles {
+				return "", false, fmt.Errorf("arg %s: @path values are only read from files on the command line", a.Name)
+			}
+			b, err := os.ReadFile(val[1:])
+			if err != nil {
+				return "", false, fmt.Errorf("arg %s: failed to read %s: %v", a.Name, val[1:], errors.Unwrap(err))
+			}
+			return strings.TrimRight(string(b), "\r\n"), true, nil
+		}
+		return val, true, nil
+	}
+	if a.Env != "" {
+		if val, ok := os.LookupEnv(a.Env); ok {
+			return val, true, nil
+		}
+	}
+	if a.Secret && store != nil {
+		val, err := store.Get(a.Name)
+		if err == nil {
+			return val, true, nil
+		}
+		if err != secrets.ErrNotFound {
+			return "", false, fmt.Errorf("arg %s: %v", a.Name, err)
+		}
+	}
+	return "", false, nil
+}
+
+// RedactArgs returns a copy of the args with the values of the secret args of the flow replaced, for records
+// of the run like the runs file.
+func (b *BuildYAML) RedactArgs(args map[string]string) map[string]string {
+	if args == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(args))
+	for key, val := range args {
+		redacted[key] = val
+	}
+	for _, arg := range b.Args {
+		if _, ok := redacted[arg.Name]; ok && arg.Secret {
+			redacted[arg.Name] = redactedValue
+		}
+	}
+	return redacted
+}
+
+// redactAllArgs returns a copy of the args with every value replaced, for records of a run whose flow couldn't be
+// loaded, so which args are secret isn't known.
+func redactAllArgs(args map[string]string) map[string]string {
+	if args == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(args))
+	for key := range args {
+		redacted[key] = redactedValue
+	}
+	return redacted
+}
+
+// Help describes the flow and the args it takes, like listCommands does for the commands.
+func (b *BuildYAML) Help() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s", b.File)
+	if b.Name != "" {
+		fmt.Fprintf(&sb, " - %s", b.Name)
+	}
+	sb.WriteString("\n")
+	if b.Description != "" {
+		fmt.Fprintf(&sb, "%s\n", b.Description)
+	}
+	if len(b.Args) == 0 {
+		sb.WriteString("Takes no args\n")
+		return sb.String()
+	}
+	sb.WriteString("Args:\n")
+	for _, arg := range b.Args {
+		var about []string
+		switch {
+		case arg.Type == ArgEnum:
+			about = append(about, fmt.Sprintf("one of %s", strings.Join(arg.Values, ", ")))
+		case arg.Type != "":
+			about = append(about, arg.Type)
+		default:
+			about = append(about, ArgString)
+		}
+		if arg.Required {
+			about = append(about, "required")
+		}
+		if arg.Default != "" {
+			about = append(about, fmt.Sprintf("default %s", arg.Default))
+		}
+		if arg.Pattern != "" {
+			about = append(about, fmt.Sprintf("matching %s", arg.Pattern))
+		}
+		if arg.Secret {
+			about = append(about, "secret")
+		}
+		if arg.Env != "" {
+			about = append(about, fmt.Sprintf("from $%s", arg.Env))
+		}
+		fmt.Fprintf(&sb, "  %s=<%s>", arg.Name, strings.Join(about, ", "))
+		if arg.Help != "" {
+			fmt.Fprintf(&sb, " - %s", arg.Help)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,259 @@
+package commander
+
+import (
+	"context"
+	"github.com/NubeIO/bios-cli/libs/runs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveArgs(t *testing.T) {
+	t.Setenv("BIOS_TEST_TOKEN", "from-env")
+	secret := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secret, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		args     string
+		passed   map[string]string
+		argFiles bool
+		want     map[string]string
+		err      string
+	}{
+		{
+			name:   "string",
+			args:   "[name]",
+			passed: map[string]string{"name": "driver", "other": "kept"},
+			want:   map[string]string{"name": "driver", "other": "kept"},
+		},
+		{
+			name:   "int",
+			args:   "[{name: port, type: int}]",
+			passed: map[string]string{"port": "1660"},
+			want:   map[string]string{"port": "1660"},
+		},
+		{
+			name:   "not an int",
+			args:   "[{name: port, type: int}]",
+			passed: map[string]string{"port": "http"},
+			err:    `invalid args: arg port must be an int, not "http"`,
+		},
+		{
+			name:   "bool",
+			args:   "[{name: enable, type: bool}]",
+			passed: map[string]string{"enable": "true"},
+			want:   map[string]string{"enable": "true"},
+		},
+		{
+			name:   "not a bool",
+			args:   "[{name: enable, type: bool}]",
+			passed: map[string]string{"enable": "maybe"},
+			err:    `invalid args: arg enable must be true or false, not "maybe"`,
+		},
+		{
+			name:   "enum",
+			args:   "[{name: arch, type: enum, values: [armv7, amd64]}]",
+			passed: map[string]string{"arch": "amd64"},
+			want:   map[string]string{"arch": "amd64"},
+		},
+		{
+			name:   "not in the enum",
+			args:   "[{name: arch, type: enum, values: [armv7, amd64]}]",
+			passed: map[string]string{"arch": "arm64"},
+			err:    `invalid args: arg arch must be one of armv7, amd64, not "arm64"`,
+		},
+		{
+			name:   "pattern",
+			args:   `[{name: tag, pattern: 'v\d+\.\d+'}]`,
+			passed: map[string]string{"tag": "v1.2"},
+			want:   map[string]string{"tag": "v1.2"},
+		},
+		{
+			name:   "pattern matches the whole value",
+			args:   `[{name: tag, pattern: 'v\d+\.\d+'}]`,
+			passed: map[string]string{"tag": "v1.2-rc1"},
+			err:    `invalid args: arg tag must match v\d+\.\d+, "v1.2-rc1" does not`,
+		},
+		{
+			name:   "pattern of a secret",
+			args:   `[{name: token, secret: true, pattern: 'gh_.*'}]`,
+			passed: map[string]string{"token": "hunter2"},
+			err:    `invalid args: arg token must match gh_.*, the value does not`,
+		},
+		{
+			name: "missing required",
+			args: "[{name: name, required: true}, {name: repo, required: true}]",
+			err:  "invalid args: missing required arg name; missing required arg repo",
+		},
+		{
+			name: "default",
+			args: "[{name: arch, default: armv7}, other]",
+			want: map[string]string{"arch": "armv7"},
+		},
+		{
+			name:   "passed over the default",
+			args:   "[{name: arch, default: armv7}]",
+			passed: map[string]string{"arch": "amd64"},
+			want:   map[string]string{"arch": "amd64"},
+		},
+		{
+			name: "env",
+			args: "[{name: token, env: BIOS_TEST_TOKEN, default: unused}]",
+			want: map[string]string{"token": "from-env"},
+		},
+		{
+			name:   "passed over the env",
+			args:   "[{name: token, env: BIOS_TEST_TOKEN}]",
+			passed: map[string]string{"token": "passed"},
+			want:   map[string]string{"token": "passed"},
+		},
+		{
+			name: "env of a required arg",
+			args: "[{name: token, env: BIOS_TEST_TOKEN, required: true}]",
+			want: map[string]string{"token": "from-env"},
+		},
+		{
+			name:     "secret from a file",
+			args:     "[{name: token, secret: true}]",
+			passed:   map[string]string{"token": "@" + secret},
+			argFiles: true,
+			want:     map[string]string{"token": "from-file"},
+		},
+		{
+			name:   "secret from a file without arg files",
+			args:   "[{name: token, secret: true}]",
+			passed: map[string]string{"token": "@" + secret},
+			err:    "invalid args: arg token: @path values are only read from files on the command line",
+		},
+		{
+			name:     "@ of an arg that isn't secret",
+			args:     "[handle]",
+			passed:   map[string]string{"handle": "@nube"},
+			argFiles: true,
+			want:     map[string]string{"handle": "@nube"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := testFlow(t, "args: "+tt.args)
+			if err := flow.checkArgs(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := flow.resolveArgs(tt.passed, nil, tt.argFiles)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordedArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		flow string
+		want map[string]string
+	}{
+		{
+			name: "secret",
+			flow: "args: [owner, {name: token, secret: true}]\nsteps:\n  - {name: build, cmd: ok}",
+			want: map[string]string{"owner": "nube", "token": redactedValue},
+		},
+		{
+			// which args are secret isn't known
+			name: "not loaded",
+			flow: "args: [owner, {name: token, type: float}]",
+			want: map[string]string{"owner": redactedValue, "token": redactedValue},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFlows(t, map[string]string{"flow.yaml": tt.flow})
+			file := filepath.Join(dir, "runs.jsonl")
+			bt := testTool()
+			bt.Runs = runs.New(file)
+			bt.RunFile(context.Background(), filepath.Join(dir, "flow.yaml"), map[string]string{"owner": "nube", "token": "s3cr3t"})
+			if b, _ := os.ReadFile(file); strings.Contains(string(b), "s3cr3t") {
+				t.Errorf("the secret is in the runs file:\n%s", b)
+			}
+			all, err := bt.Runs.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != 1 || !reflect.DeepEqual(all[0].Args, tt.want) {
+				t.Errorf("got runs %+v", all)
+			}
+		})
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		err  string
+	}{
+		{"[{help: no name}]", "an arg has no name"},
+		{"[{name: arch, type: enum}]", "arg arch is an enum without values"},
+		{"[{name: port, type: float}]", `arg port has an unknown type "float", try: string, int, bool or enum`},
+		{"[{name: tag, pattern: 'v('}]", "arg tag has an invalid pattern: error parsing regexp: missing closing ): `v(`"},
+		{"[{name: port, type: int, default: http}]", `the default of arg port must be an int, not "http"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			err := testFlow(t, "args: "+tt.args).checkArgs()
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("got error %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestHelp(t *testing.T) {
+	flow := testFlow(t, `
+name: update
+description: Download and install a build
+args:
+  - owner
+  - name: arch
+    type: enum
+    values: [armv7, amd64]
+    default: armv7
+    help: the arch of the build
+  - name: port
+    type: int
+    required: true
+  - name: tag
+    pattern: 'v\d+'
+  - name: token
+    secret: true
+    env: GITHUB_TOKEN
+`)
+	want := flow.File + ` - update
+Download and install a build
+Args:
+  owner=<string>
+  arch=<one of armv7, amd64, default armv7> - the arch of the build
+  port=<int, required>
+  tag=<string, matching v\d+>
+  token=<string, secret, from $GITHUB_TOKEN>
+`
+	if got := flow.Help(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	flow = testFlow(t, "name: noop")
+	if got, want := flow.Help(), flow.File+" - noop\nTakes no args\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -1,64 +1,86 @@
 package commander
 
 import (
+	"context"
 	"fmt"
+	"github.com/NubeIO/bios-cli/libs/events"
+	"github.com/NubeIO/bios-cli/libs/execute/commands"
+	"github.com/NubeIO/bios-cli/libs/runs"
+	"github.com/NubeIO/bios-cli/libs/secrets"
 	systeminfo "github.com/NubeIO/bios-cli/libs/system"
 	"gopkg.in/yaml.v3"
 
-	"os"
-	"os/exec"
 	"strings"
 )
 
-// CommandHandler defines the signature for functions that handle commands.
-type CommandHandler func(params interface{}) (interface{}, error)
+// CommandHandler defines the signature for functions that handle commands. Handlers must stop
+// what they are doing, and kill any child process, when ctx is cancelled.
+type CommandHandler func(ctx context.Context, params interface{}) (interface{}, error)
 
 // BuildTool represents a build tool instance.
 type BuildTool struct {
 	CommandMap map[string]CommandHandler
 	Commands   map[string]Command
-	buildYAML  BuildYAML
-	system     systeminfo.System
+	OnStep     StepHook       // optional, called as each step of RunFlow progresses
+	Runs       runs.Store     // optional, every RunFlow is recorded here
+	Events     *events.Broker // optional, the output of every step is published here
+	LogDir     string         // optional, the output of every RunFlow is logged to a file in this dir
+	DryRun     bool           // plan the steps of RunFlow instead of running them, see PlanFunc
+	Secrets    secrets.Store  // optional, where the secret args that aren't passed are looked up
+	// ArgFiles lets secret args be passed as @path to read them from a file. Only set it for a trusted caller,
+	// like the CLI, not for args that come over the REST API, or they could read any file bios can
+	ArgFiles  bool
+	buildYAML BuildYAML
+	system    systeminfo.System
+	systemd   commands.Commands
 }
 
 type Command struct {
 	Func CommandHandler
 	Name string
 	Help string
+	Plan PlanFunc // optional, describes what Func would do for a dry run
 }
 
 // NewBuildTool creates a new BuildTool instance.
 func NewBuildTool() *BuildTool {
 	bt := &BuildTool{
-		system: systeminfo.New(),
+		system:  systeminfo.New(),
+		systemd: commands.New(),
 	}
 	bt.Commands = make(map[string]Command)
 	bt.CommandMap = map[string]CommandHandler{
-		"listCommands":    bt.handleListCommands,
-		"systemctl":       bt.handleSystemctl,
-		"bash":            bt.handleRunBash,
-		"http":            bt.handleRestyHTTPRequest,
-		"github-download": bt.handleGitHubDownload,
-		"dirs":            bt.handleFiles,
-		"systemctl-file":  bt.handleSystemctlFile,
-		"time":            bt.time,
-		"system":          bt.handleSystemInfo,
+		"listCommands":     bt.handleListCommands,
+		"systemctl":        bt.handleSystemctl,
+		"bash":             bt.handleRunBash,
+		"http":             bt.handleRestyHTTPRequest,
+		"github-download":  bt.handleGitHubDownload,
+		"dirs":             bt.handleFiles,
+		"systemctl-file":   bt.handleSystemctlFile,
+		"systemctl-dropin": bt.handleSystemctlDropIn,
+		"systemctl-timer":  bt.handleSystemctlTimer,
+		"time":             bt.time,
+		"system":           bt.handleSystemInfo,
+		"flow":             bt.handleFlow,
 	}
 	bt.Commands["listCommands"] = Command{Func: bt.handleListCommands, Name: "listCommands", Help: "List all available commands"}
-	bt.Commands["systemctl"] = Command{Func: bt.handleSystemctl, Name: "systemctl", Help: "Manage systemd services"}
-	bt.Commands["bash"] = Command{Func: bt.handleRunBash, Name: "runBash", Help: "Execute a bash command"}
-	bt.Commands["http"] = Command{Func: bt.handleRestyHTTPRequest, Name: "http", Help: "Make an HTTP request using Resty"}
-	bt.Commands["github-download"] = Command{Func: bt.handleGitHubDownload, Name: "github-download", Help: "Download and unzip a GitHub release"}
-	bt.Commands["dirs"] = Command{Func: bt.handleFiles, Name: "dirs", Help: "Add/Edit files and dirs"}
-	bt.Commands["systemctl-file"] = Command{Func: bt.handleFiles, Name: "dirs", Help: "Generates a systemctl file"}
+	bt.Commands["systemctl"] = Command{Func: bt.handleSystemctl, Name: "systemctl", Help: "Manage systemd services", Plan: bt.planSystemctl}
+	bt.Commands["bash"] = Command{Func: bt.handleRunBash, Name: "runBash", Help: "Execute a bash command", Plan: bt.planRunBash}
+	bt.Commands["http"] = Command{Func: bt.handleRestyHTTPRequest, Name: "http", Help: "Make an HTTP request using Resty", Plan: bt.planRestyHTTPRequest}
+	bt.Commands["github-download"] = Command{Func: bt.handleGitHubDownload, Name: "github-download", Help: "Download and unzip a GitHub release", Plan: bt.planGitHubDownload}
+	bt.Commands["dirs"] = Command{Func: bt.handleFiles, Name: "dirs", Help: "Add/Edit files and dirs", Plan: bt.planFiles}
+	bt.Commands["systemctl-file"] = Command{Func: bt.handleSystemctlFile, Name: "systemctl-file", Help: "Generates a systemctl file", Plan: bt.planSystemctlFile}
+	bt.Commands["systemctl-dropin"] = Command{Func: bt.handleSystemctlDropIn, Name: "systemctl-dropin", Help: "Create, list and remove the drop-ins of a unit, or show its effective configuration", Plan: bt.planSystemctlDropIn}
+	bt.Commands["systemctl-timer"] = Command{Func: bt.handleSystemctlTimer, Name: "systemctl-timer", Help: "Create a timer and the service it starts, or list the timers with their next and last trigger times", Plan: bt.planSystemctlTimer}
 	bt.Commands["time"] = Command{Func: bt.time, Name: "time", Help: "Generates a systemctl file"}
 	bt.Commands["system"] = Command{Func: bt.handleSystemInfo, Name: "system", Help: "Get host info like IP, Time"}
+	bt.Commands["flow"] = Command{Func: bt.handleFlow, Name: "flow", Help: "Run another flow file with args", Plan: bt.planFlow}
 
 	return bt
 }
 
 // handleListCommands lists all available commands and their descriptions.
-func (bt *BuildTool) handleListCommands(_ interface{}) (interface{}, error) {
+func (bt *BuildTool) handleListCommands(_ context.Context, _ interface{}) (interface{}, error) {
 	fmt.Println("Available commands:")
 	for _, cmd := range bt.Commands {
 		fmt.Printf("%s - %s\n", cmd.Name, cmd.Help)
@@ -68,44 +90,113 @@ func (bt *BuildTool) handleListCommands(_ interface{}) (interface{}, error) {
 
 // BuildStep represents a single step in the build process.
 type BuildStep struct {
-	Name   string      `yaml:"name"`
-	Cmd    string      `yaml:"cmd"`
-	Params interface{} `yaml:"params"`
+	Name     string      `yaml:"name"`
+	Cmd      string      `yaml:"cmd"`
+	Params   interface{} `yaml:"params"`
+	If       string      `yaml:"if"`       // optional expression, the step is skipped when it is false
+	Register Register    `yaml:"register"` // optional vars to set from the response of the step
+	Retry    *Retry      `yaml:"retry"`    // optional, retry the step when it fails
+	Timeout  string      `yaml:"timeout"`  // optional, like 30s, applies to each attempt of the step
+	// ContinueOnError keeps the flow going, and successful, when this step fails
+	ContinueOnError bool `yaml:"continueOnError"`
+	// Rollback steps undo this step, they are run when a later step fails the flow
+	Rollback []BuildStep `yaml:"rollback"`
+	// Needs are the names of the steps this step waits for, see BuildYAML.MaxParallel
+	Needs []string `yaml:"needs"`
+	// Foreach runs the step once for each item of a list, or of a ${var} holding one, see foreachItems
+	Foreach interface{} `yaml:"foreach"`
+	// Env is added to the env of the flow for the commands of the step
+	Env     map[string]string `yaml:"env"`
+	Workdir string            `yaml:"workdir"` // optional, relative to the workdir of the flow
+}
+
+// Register maps var names to a JSON path into the response of a step, like $.assets[0].name. It is written
+// either as a map, or as a single var name to register the whole response.
+type Register map[string]string
+
+// UnmarshalYAML accepts `register: myVar` as well as `register: {myVar: $.path}`.
+func (r *Register) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*r = Register{value.Value: ""}
+		return nil
+	}
+	var m map[string]string
+	if err := value.Decode(&m); err != nil {
+		return err
+	}
+	*r = m
+	return nil
 }
 
 // BuildYAML represents the structure of the build.yaml file.
 type BuildYAML struct {
-	Shell string      `yaml:"shell"`
-	Name  string      `yaml:"name"`
-	Args  []string    `yaml:"args"`
-	Vars  []Variable  `yaml:"vars"`
-	Steps []BuildStep `yaml:"steps"`
+	File  string `yaml:"-"` // the file the flow was loaded from
+	Shell string `yaml:"shell"`
+	Name  string `yaml:"name"`
+	// Description says what the flow does, it is shown by validate and the help of the flow
+	Description string `yaml:"description"`
+	Timeout     string `yaml:"timeout"` // optional, like 10m, for the whole flow
+	OnError     string `yaml:"onError"` // stop (default) or continue running the steps after a step fails
+	// MaxParallel is how many steps run at the same time when the steps use needs:, otherwise they run one by one
+	MaxParallel int         `yaml:"maxParallel"`
+	Args        []Arg       `yaml:"args"`
+	Vars        []Variable  `yaml:"vars"`
+	Steps       []BuildStep `yaml:"steps"`
+	// Finally steps run after the steps no matter what, like cleaning up temp download dirs. Always is an alias.
+	Finally []BuildStep `yaml:"finally"`
+	Always  []BuildStep `yaml:"always"`
+	// Include are flow files, relative to this one, whose args, vars and steps are merged in before its own
+	Include []string `yaml:"include"`
+	// Outputs are returned to the flow step that ran this flow, like path: ${steps.download.path}
+	Outputs map[string]string `yaml:"outputs"`
+	// Env is set for the commands of every step, on top of the environment bios runs in
+	Env map[string]string `yaml:"env"`
+	// Workdir is where the commands run and relative paths of the params resolve, relative to this file.
+	// By default it is the working dir bios was started in.
+	Workdir string `yaml:"workdir"`
+}
+
+// finallySteps returns the steps of the finally: and always: sections.
+func (b *BuildYAML) finallySteps() []BuildStep {
+	return append(append([]BuildStep(nil), b.Finally...), b.Always...)
 }
 
 // Variable represents a variable in the YAML file.
 type Variable struct {
-	Name  string `yaml:"name"`
-	Value string `yaml:"value"`
+	Name  string      `yaml:"name"`
+	Value interface{} `yaml:"value"`
 }
 
-// LoadBuildYAML loads the build.yaml file into a BuildYAML struct.
+// LoadBuildYAML loads the build.yaml file into a BuildYAML struct, merging in the files it includes.
 func (bt *BuildTool) LoadBuildYAML(filename string) (*BuildYAML, error) {
-	yamlFile, err := os.ReadFile(filename)
+	buildYAML, err := loadFlowFile(filename, nil)
 	if err != nil {
 		return nil, err
 	}
-
-	var buildYAML BuildYAML
-	err = yaml.Unmarshal(yamlFile, &buildYAML)
-	if err != nil {
+	if err := buildYAML.checkArgs(); err != nil {
+		return nil, err
+	}
+	if err := checkOnError(buildYAML.OnError); err != nil {
 		return nil, err
 	}
-	bt.buildYAML = buildYAML
-	return &buildYAML, nil
+	if _, _, err := buildYAML.stepGraph(); err != nil {
+		return nil, err
+	}
+	bt.buildYAML = *buildYAML
+	return buildYAML, nil
+}
+
+// checkOnError checks the onError: of a flow, empty is stop.
+func checkOnError(onError string) error {
+	switch onError {
+	case "", OnErrorStop, OnErrorContinue:
+		return nil
+	}
+	return fmt.Errorf("unknown onError %q, try: %s or %s", onError, OnErrorStop, OnErrorContinue)
 }
 
 // UpdateVar updates a variable in the BuildYAML.
-func (bt *BuildTool) UpdateVar(name string, value string) {
+func (bt *BuildTool) UpdateVar(name string, value interface{}) {
 	for i, v := range bt.buildYAML.Vars {
 		if v.Name == name {
 			bt.buildYAML.Vars[i].Value = value
@@ -116,17 +207,27 @@ func (bt *BuildTool) UpdateVar(name string, value string) {
 	bt.buildYAML.Vars = append(bt.buildYAML.Vars, Variable{Name: name, Value: value})
 }
 
-// ExecuteStep executes a single step in the build process.
-func (bt *BuildTool) ExecuteStep(step BuildStep) (interface{}, error) {
+// ExecuteStep executes a single step in the build process, retrying it as per its retry policy.
+func (bt *BuildTool) ExecuteStep(ctx context.Context, step BuildStep) (interface{}, error) {
+	ret, _, err := bt.executeStep(ctx, step)
+	return ret, err
+}
+
+// executeStep executes a single step, it also returns every attempt made when the step has a retry policy.
+func (bt *BuildTool) executeStep(ctx context.Context, step BuildStep) (interface{}, []Attempt, error) {
 	handler, ok := bt.CommandMap[step.Cmd]
 	if !ok {
-		return nil, fmt.Errorf("unknown command: %s", step.Cmd)
+		return nil, nil, fmt.Errorf("unknown command: %s", step.Cmd)
+	}
+	timeout, err := parseTimeout(step.Timeout)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return handler(step.Params)
+	return bt.executeWithRetry(ctx, handler, step, timeout)
 }
 
-func (bt *BuildTool) executeCommand(commandName string, params interface{}) error {
+func (bt *BuildTool) executeCommand(ctx context.Context, commandName string, params interface{}) error {
 	var cmdString string
 	// Check if params is a slice of strings
 	switch p := params.(type) {
@@ -138,7 +239,7 @@ func (bt *BuildTool) executeCommand(commandName string, params interface{}) erro
 		return fmt.Errorf("invalid params type for %s command", commandName)
 	}
 
-	fmt.Println("[", cmdString, "]")
+	fmt.Fprintln(stepStdout(ctx), "[", cmdString, "]")
 	// Split the command string into command and arguments
 	parts := strings.Fields(cmdString)
 	if len(parts) < 1 {
@@ -146,9 +247,9 @@ func (bt *BuildTool) executeCommand(commandName string, params interface{}) erro
 	}
 	cmd := parts[0]
 	args := parts[1:]
-	execCmd := exec.Command(commandName, append([]string{cmd}, args...)...)
-	execCmd.Stdout = os.Stdout
-	execCmd.Stderr = os.Stderr
+	execCmd := commandContext(ctx, commandName, append([]string{cmd}, args...)...)
+	execCmd.Stdout = stepStdout(ctx)
+	execCmd.Stderr = stepStderr(ctx)
 	err := execCmd.Run()
 	if err != nil {
 		return fmt.Errorf("failed to run %s command: %v", commandName, err)
@@ -1,22 +1,21 @@
 package commander
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"os"
-	"os/exec"
 )
 
-func (bt *BuildTool) handleRunBash(params interface{}) (interface{}, error) {
+func (bt *BuildTool) handleRunBash(ctx context.Context, params interface{}) (interface{}, error) {
 	// Assert that params is a string
 	cmdString, ok := params.(string)
 	if !ok {
 		return nil, errors.New("invalid params type for runBash command")
 	}
 
-	cmd := exec.Command("bash", "-c", cmdString)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := commandContext(ctx, "bash", "-c", cmdString)
+	cmd.Stdout = stepStdout(ctx)
+	cmd.Stderr = stepStderr(ctx)
 	err := cmd.Run()
 	if err != nil {
 		return nil, fmt.Errorf("failed to run bash command: %v", err)
@@ -24,3 +23,11 @@ func (bt *BuildTool) handleRunBash(params interface{}) (interface{}, error) {
 
 	return nil, nil
 }
+
+func (bt *BuildTool) planRunBash(_ context.Context, params interface{}) (*Action, error) {
+	cmdString, ok := params.(string)
+	if !ok {
+		return nil, errors.New("invalid params type for runBash command")
+	}
+	return &Action{Description: "run a bash command", Command: cmdString}, nil
+}
@@ -0,0 +1,177 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// defaultMaxParallel is how many steps of a flow using needs: run at the same time, unless it sets maxParallel.
+const defaultMaxParallel = 4
+
+// stepGraph returns the indexes of the steps each step needs. When no step of the flow uses needs:, every step
+// needs the one before it, so the steps run one at a time in order.
+func (b *BuildYAML) stepGraph() (deps [][]int, explicit bool, err error) {
+	deps = make([][]int, len(b.Steps))
+	for _, step := range b.Steps {
+		if len(step.Needs) > 0 {
+			explicit = true
+		}
+	}
+	if !explicit {
+		for i := 1; i < len(b.Steps); i++ {
+			deps[i] = []int{i - 1}
+		}
+		return deps, false, nil
+	}
+
+	byName := make(map[string]int)
+	for i, step := range b.Steps {
+		if _, ok := byName[step.Name]; ok {
+			byName[step.Name] = -1 // ambiguous, only an error if something needs it
+			continue
+		}
+		byName[step.Name] = i
+	}
+	for i, step := range b.Steps {
+		for _, need := range step.Needs {
+			j, ok := byName[need]
+			if !ok {
+				return nil, true, fmt.Errorf("step %d (%s) needs unknown step: %s", i, step.Name, need)
+			}
+			if j < 0 {
+				return nil, true, fmt.Errorf("step %d (%s) needs %s, but more than one step has that name", i, step.Name, need)
+			}
+			deps[i] = append(deps[i], j)
+		}
+	}
+	if cycle := findCycle(deps); cycle != nil {
+		names := make([]string, len(cycle))
+		for i, j := range cycle {
+			names[i] = b.Steps[j].Name
+		}
+		return nil, true, fmt.Errorf("steps have a dependency cycle: %s", strings.Join(names, " needs "))
+	}
+	return deps, true, nil
+}
+
+// findCycle returns the indexes of the steps making a cycle, or nil if there is none.
+func findCycle(deps [][]int) []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(deps))
+	var stack []int
+	var visit func(i int) []int
+	visit = func(i int) []int {
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, j := range deps[i] {
+			switch state[j] {
+			case visiting:
+				for k, s := range stack {
+					if s == j {
+						return append(append([]int(nil), stack[k:]...), j)
+					}
+				}
+			case unvisited:
+				if cycle := visit(j); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range deps {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// runGraph runs the steps of the flow as soon as the steps they need are done, at most maxParallel at a time,
+// and returns the steps that completed in the order they completed. With explicit needs: a step is skipped when
+// a step it needs didn't succeed.
+func (r *flowRun) runGraph(ctx context.Context, deps [][]int, explicit bool) []BuildStep {
+	steps := r.flow.Steps
+	maxParallel := 1
+	if explicit {
+		maxParallel = r.flow.MaxParallel
+		if maxParallel <= 0 {
+			maxParallel = defaultMaxParallel
+		}
+	}
+
+	type result struct {
+		i   int
+		out *Response
+	}
+	results := make([]*Response, len(steps))
+	started := make([]bool, len(steps))
+	done := make(chan result)
+	var completed []BuildStep
+	running, finished := 0, 0
+	for finished < len(steps) {
+		for i, step := range steps {
+			if started[i] || running >= maxParallel {
+				continue
+			}
+			ready, reason := r.ready(i, deps[i], results, explicit)
+			if !ready {
+				continue
+			}
+			started[i] = true
+			if reason != "" {
+				results[i] = r.skip(i, step, "", reason)
+				finished++
+				continue
+			}
+			running++
+			go func(i int, step BuildStep) {
+				out := r.runStep(ctx, i, step, "")
+				r.checkFailed(step, out)
+				done <- result{i: i, out: out}
+			}(i, step)
+		}
+		if running == 0 {
+			continue
+		}
+		res := <-done
+		results[res.i] = res.out
+		running--
+		finished++
+		if succeeded(res.out) {
+			completed = append(completed, steps[res.i])
+		}
+	}
+	return completed
+}
+
+// ready reports whether the step can be started, because the steps it needs are done. When the step should be
+// skipped instead of run, the reason is returned too.
+func (r *flowRun) ready(i int, deps []int, results []*Response, explicit bool) (bool, string) {
+	for _, j := range deps {
+		if results[j] == nil {
+			return false, ""
+		}
+	}
+	// a dry run plans every step, to show all the steps that would fail
+	if failed := r.failure(); failed != nil && r.flow.OnError != OnErrorContinue && !r.bt.DryRun {
+		return true, fmt.Sprintf("step %d (%s) failed", failed.StepCount, failed.Name)
+	}
+	if explicit {
+		for _, j := range deps {
+			if !succeeded(results[j]) && !results[j].ContinueOnError {
+				return true, fmt.Sprintf("needs step %d (%s) which %s", j, results[j].Name, results[j].Status)
+			}
+		}
+	}
+	return true, ""
+}
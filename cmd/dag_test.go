@@ -0,0 +1,229 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testFlow writes the flow to a temp dir and loads it like bios build does.
+func testFlow(t *testing.T, content string) *BuildYAML {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "flow.yaml")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	flow, err := loadFlowFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return flow
+}
+
+// testTool is a BuildTool with commands that don't touch the host: ok returns its params, fail fails with them
+// and sleep waits for the duration of its params before returning them.
+func testTool() *BuildTool {
+	bt := NewBuildTool()
+	bt.CommandMap["ok"] = func(_ context.Context, params interface{}) (interface{}, error) {
+		return params, nil
+	}
+	bt.CommandMap["fail"] = func(_ context.Context, params interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("failed: %v", params)
+	}
+	bt.CommandMap["sleep"] = func(ctx context.Context, params interface{}) (interface{}, error) {
+		d, err := time.ParseDuration(fmt.Sprint(params))
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(d):
+			return params, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return bt
+}
+
+// statuses returns the name and status of each response, like build=succeeded.
+func statuses(resp []*Response) []string {
+	var out []string
+	for _, r := range resp {
+		out = append(out, fmt.Sprintf("%s=%s", r.Name, r.Status))
+	}
+	return out
+}
+
+func TestStepGraphErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps string
+		err   string
+	}{
+		{
+			name: "cycle",
+			steps: `
+  - {name: a, cmd: ok, needs: [c]}
+  - {name: b, cmd: ok, needs: [a]}
+  - {name: c, cmd: ok, needs: [b]}`,
+			err: "steps have a dependency cycle: a needs c needs b needs a",
+		},
+		{
+			name: "self",
+			steps: `
+  - {name: a, cmd: ok, needs: [a]}`,
+			err: "steps have a dependency cycle: a needs a",
+		},
+		{
+			name: "unknown",
+			steps: `
+  - {name: a, cmd: ok}
+  - {name: b, cmd: ok, needs: [missing]}`,
+			err: "step 1 (b) needs unknown step: missing",
+		},
+		{
+			name: "ambiguous",
+			steps: `
+  - {name: a, cmd: ok}
+  - {name: a, cmd: ok}
+  - {name: b, cmd: ok, needs: [a]}`,
+			err: "step 2 (b) needs a, but more than one step has that name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := testFlow(t, "steps:"+tt.steps)
+			_, _, err := flow.stepGraph()
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got error %v, want %s", err, tt.err)
+			}
+			resp := testTool().RunFlow(context.Background(), flow, nil)
+			if len(resp) != 1 || resp[0].Status != StatusFailed || resp[0].Error != "flow: "+tt.err {
+				t.Errorf("got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestRunGraph(t *testing.T) {
+	tests := []struct {
+		name  string
+		flow  string
+		want  []string
+		order []string // the order the steps must run in, when it matters
+	}{
+		{
+			name: "in order without needs",
+			flow: `
+steps:
+  - {name: a, cmd: sleep, params: 20ms}
+  - {name: b, cmd: ok}`,
+			want: []string{"a=succeeded", "b=succeeded"},
+		},
+		{
+			name: "response in file order",
+			flow: `
+steps:
+  - {name: slow, cmd: sleep, params: 50ms}
+  - {name: fast, cmd: ok}
+  - {name: last, cmd: ok, needs: [slow, fast]}`,
+			want: []string{"slow=succeeded", "fast=succeeded", "last=succeeded"},
+		},
+		{
+			name: "skipped when a dependency fails",
+			flow: `
+onError: continue
+steps:
+  - {name: build, cmd: fail}
+  - {name: test, cmd: ok, needs: [build]}
+  - {name: lint, cmd: ok}
+  - {name: deploy, cmd: ok, needs: [test, lint]}`,
+			want: []string{"build=failed", "test=skipped", "lint=succeeded", "deploy=skipped"},
+		},
+		{
+			name: "not skipped when the dependency continues on error",
+			flow: `
+steps:
+  - {name: build, cmd: fail, continueOnError: true}
+  - {name: test, cmd: ok, needs: [build]}`,
+			want: []string{"build=failed", "test=succeeded"},
+		},
+		{
+			name: "later steps skipped when the flow failed",
+			flow: `
+steps:
+  - {name: build, cmd: fail}
+  - {name: test, cmd: ok}`,
+			want: []string{"build=failed", "test=skipped"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := testTool().RunFlow(context.Background(), testFlow(t, tt.flow), nil)
+			if got := statuses(resp); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			for i, r := range resp {
+				if r.StepCount != i {
+					t.Errorf("response %d is of step %d", i, r.StepCount)
+				}
+			}
+		})
+	}
+}
+
+func TestOnErrorTypo(t *testing.T) {
+	flow := testFlow(t, "onError: contnue\nsteps:\n  - {name: build, cmd: fail}\n  - {name: test, cmd: ok}")
+	want := `unknown onError "contnue", try: stop or continue`
+	if _, err := testTool().LoadBuildYAML(flow.File); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+	resp := testTool().RunFlow(context.Background(), flow, nil)
+	if len(resp) != 1 || resp[0].Status != StatusFailed || resp[0].Error != "flow: "+want {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestRunGraphMaxParallel(t *testing.T) {
+	for _, maxParallel := range []int{1, 2, 3} {
+		t.Run(fmt.Sprint(maxParallel), func(t *testing.T) {
+			var mu sync.Mutex
+			running, most := 0, 0
+			bt := testTool()
+			bt.CommandMap["count"] = func(_ context.Context, params interface{}) (interface{}, error) {
+				mu.Lock()
+				running++
+				if running > most {
+					most = running
+				}
+				mu.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil, nil
+			}
+			flow := testFlow(t, fmt.Sprintf(`
+maxParallel: %d
+steps:
+  - {name: start, cmd: ok}
+  - {name: a, cmd: count, needs: [start]}
+  - {name: b, cmd: count, needs: [start]}
+  - {name: c, cmd: count, needs: [start]}
+  - {name: d, cmd: count, needs: [start]}
+  - {name: e, cmd: count, needs: [start]}`, maxParallel))
+			resp := bt.RunFlow(context.Background(), flow, nil)
+			if failed := Failure(resp); failed != nil {
+				t.Fatalf("step %s failed: %s", failed.Name, failed.Error)
+			}
+			if most != maxParallel {
+				t.Errorf("got %d steps running at the same time, want %d", most, maxParallel)
+			}
+		})
+	}
+}
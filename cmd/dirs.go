@@ -1,13 +1,15 @@
 package commander
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func (bt *BuildTool) handleFiles(params interface{}) (interface{}, error) {
+// handleFiles runs a file operation, like mkdir ./build. Relative paths are relative to the workdir of the flow.
+func (bt *BuildTool) handleFiles(ctx context.Context, params interface{}) (interface{}, error) {
 	var paramList []string
 	switch p := params.(type) {
 	case string:
@@ -15,13 +17,8 @@ func (bt *BuildTool) handleFiles(params interface{}) (interface{}, error) {
 	case []string:
 		paramList = p
 	case []interface{}:
-		for _, v := range p {
-			if str, ok := v.(string); ok {
-				paramList = append(paramList, str)
-			} else {
-				return nil, fmt.Errorf("invalid element type in []interface{}")
-			}
-		}
+		// a lone ${var} resolves to the value of the var, which may be a number
+		paramList = paramStrings(p)
 	default:
 		return nil, fmt.Errorf("invalid params type for file operations")
 	}
@@ -30,7 +27,7 @@ func (bt *BuildTool) handleFiles(params interface{}) (interface{}, error) {
 	}
 
 	operation := paramList[0]
-	filePath := paramList[1]
+	filePath := resolvePath(ctx, paramList[1])
 	fileOps := fileImpl{}
 
 	switch operation {
@@ -70,7 +67,7 @@ func (bt *BuildTool) handleFiles(params interface{}) (interface{}, error) {
 		if len(paramList) < 3 {
 			return nil, fmt.Errorf("unzip requires source and destination")
 		}
-		dest := paramList[2]
+		dest := resolvePath(ctx, paramList[2])
 
 		err := unzip(filePath, dest)
 		if err != nil {
@@ -81,7 +78,7 @@ func (bt *BuildTool) handleFiles(params interface{}) (interface{}, error) {
 		if len(paramList) < 3 {
 			return nil, fmt.Errorf("move requires source and destination")
 		}
-		dest := paramList[2]
+		dest := resolvePath(ctx, paramList[2])
 		if _, err := os.Stat(dest); err == nil {
 			// Remove the existing file or directory at the destination
 			if err := os.RemoveAll(dest); err != nil {
@@ -132,6 +129,33 @@ func (bt *BuildTool) handleFiles(params interface{}) (interface{}, error) {
 	return nil, nil
 }
 
+func (bt *BuildTool) planFiles(ctx context.Context, params interface{}) (*Action, error) {
+	paramList := paramStrings(params)
+	if len(paramList) < 2 {
+		return nil, fmt.Errorf("invalid params for file operations")
+	}
+	operation := paramList[0]
+	filePath := resolvePath(ctx, paramList[1])
+	switch operation {
+	case "mkdir":
+		return &Action{Description: fmt.Sprintf("create the dir %s", filePath), Files: []string{filePath}}, nil
+	case "delete":
+		return &Action{Description: fmt.Sprintf("delete %s", filePath), Files: []string{filePath}}, nil
+	case "unzip", "mv", "rename":
+		if len(paramList) < 3 {
+			return nil, fmt.Errorf("%s requires a source and a destination", operation)
+		}
+		dest := resolvePath(ctx, paramList[2])
+		if operation == "rename" {
+			dest = filepath.Join(filepath.Dir(filePath), paramList[2])
+		}
+		return &Action{Description: fmt.Sprintf("%s %s to %s", operation, filePath, dest), Files: []string{filePath, dest}}, nil
+	case "walkup", "walkdown", "listfiles":
+		return &Action{Description: fmt.Sprintf("%s %s", operation, filePath)}, nil
+	}
+	return nil, fmt.Errorf("unsupported file operation: %s", operation)
+}
+
 type fileImpl struct {
 	permissions os.FileMode
 }
@@ -0,0 +1,29 @@
+package commander
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFilesNumberParams checks that list params take numbers, as a lone ${var} resolves to the value of the var.
+func TestFilesNumberParams(t *testing.T) {
+	dir := t.TempDir()
+	bt := testTool()
+	flow := testFlow(t, `
+workdir: `+dir+`
+vars: [{name: n, value: 7}, {name: ratio, value: 1.5}]
+steps:
+  - {name: int, cmd: dirs, params: [mkdir, "${n}"]}
+  - {name: float, cmd: dirs, params: [mkdir, "${ratio}"]}
+  - {name: each, cmd: dirs, foreach: [a, b], params: [mkdir, "${index}"]}`)
+	if failed := Failure(bt.RunFlow(context.Background(), flow, nil)); failed != nil {
+		t.Fatalf("step %s failed: %s", failed.Name, failed.Error)
+	}
+	for _, name := range []string{"7", "1.5", "0", "1"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || !info.IsDir() {
+			t.Errorf("no dir %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/execute/commands"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultUnitDir is where the unit files and drop-ins of the admin are, and where systemctl-dropin writes them.
+const DefaultUnitDir = "/etc/systemd/system"
+
+// unitSearchPaths are the dirs systemd loads the unit files and their drop-ins from, the first ones win.
+var unitSearchPaths = []string{DefaultUnitDir, "/run/systemd/system", "/usr/local/lib/systemd/system", "/usr/lib/systemd/system", "/lib/systemd/system"}
+
+// Operations of systemctl-dropin.
+const (
+	DropInCreate = "create" // write the drop-in from the sections of the params, the default
+	DropInList   = "list"   // the drop-ins of the unit in the location
+	DropInRemove = "remove" // remove the drop-in, and the .d dir once it is empty
+	DropInShow   = "show"   // the effective configuration of the unit, with all its drop-ins applied
+)
+
+// dropIn is a drop-in of a unit, as listed by systemctl-dropin.
+type dropIn struct {
+	Name     string                         `json:"name"`
+	File     string                         `json:"file"`
+	Sections map[string]map[string][]string `json:"sections"`
+}
+
+// unitConfig is the effective configuration of a unit, as shown by systemctl-dropin.
+type unitConfig struct {
+	Unit     string                         `json:"unit"`
+	Files    []string                       `json:"files"` // the unit file and its drop-ins, in the order they apply
+	Sections map[string]map[string][]string `json:"sections"`
+}
+
+// dropInParams are the params of systemctl-dropin:
+//
+//	op: create
+//	name: driver              # the unit, a service when it has no extension
+//	dropin: restart           # the file of the drop-in, restart.conf, override by default
+//	location: /etc/systemd/system
+//	service:
+//	  Restart: on-failure
+type dropInParams struct {
+	op       string
+	unit     string
+	name     string
+	location string
+	dir      string // the .d dir of the unit in the location
+	file     string // the drop-in in dir
+}
+
+func newDropInParams(ctx context.Context, params interface{}) (*dropInParams, map[string]interface{}, error) {
+	paramMap, ok := params.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid params for systemctl-dropin")
+	}
+	unit := trimNewline(paramString(paramMap, "name"))
+	if unit == "" {
+		return nil, nil, fmt.Errorf("systemctl-dropin requires the name of a unit")
+	}
+	for _, key := range []string{"name", "dropin"} {
+		if err := checkFileName(key, paramString(paramMap, key)); err != nil {
+			return nil, nil, err
+		}
+	}
+	p := &dropInParams{
+		op:       paramString(paramMap, "op"),
+		unit:     (&SystemctlService{Name: unit}).FileName(),
+		name:     strings.TrimSuffix(paramString(paramMap, "dropin"), ".conf"),
+		location: paramString(paramMap, "location"),
+	}
+	if p.op == "" {
+		p.op = DropInCreate
+	}
+	if p.name == "" {
+		p.name = "override"
+	}
+	if p.location == "" {
+		p.location = DefaultUnitDir
+	}
+	p.location = resolvePath(ctx, p.location)
+	p.dir = filepath.Join(p.location, p.unit+".d")
+	p.file = filepath.Join(p.dir, p.name+".conf")
+	switch p.op {
+	case DropInCreate, DropInList, DropInRemove, DropInShow:
+	default:
+		return nil, nil, fmt.Errorf("unknown systemctl-dropin operation %q, try: %s, %s, %s or %s", p.op, DropInCreate, DropInList, DropInRemove, DropInShow)
+	}
+	return p, paramMap, nil
+}
+
+// checkFileName fails on the name of a unit or a drop-in that isn't a single file name, as it would write or
+// remove a file outside of the dir of the unit.
+func checkFileName(key, name string) error {
+	if strings.Contains(name, "/") || strings.Contains(name, "..") {
+		return fmt.Errorf("the %s %q must be a file name, without / or ..", key, name)
+	}
+	return nil
+}
+
+func (bt *BuildTool) handleSystemctlDropIn(ctx context.Context, params interface{}) (interface{}, error) {
+	p, paramMap, err := newDropInParams(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	switch p.op {
+	case DropInList:
+		return listDropIns(p.dir)
+	case DropInShow:
+		return effectiveUnit(p.unit, p.location)
+	case DropInRemove:
+		err := os.Remove(p.file)
+		if os.IsNotExist(err) {
+			return &unitFileResult{File: p.file}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove drop-in: %v", err)
+		}
+		// only removed when it is empty
+		os.Remove(p.dir)
+		fmt.Fprintf(stepStdout(ctx), "Drop-in %s removed\n", p.file)
+		return &unitFileResult{File: p.file, Changed: true}, nil
+	}
+
+	service, content, warnings, err := dropInService(p, paramMap)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(stepStderr(ctx), "warning: %s\n", warning)
+	}
+	previous, _ := os.ReadFile(p.file)
+	if string(previous) == content {
+		return &unitFileResult{File: p.file, Warnings: warnings}, nil
+	}
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create drop-in dir: %v", err)
+	}
+	if err := writeUnitFile(p.file, content); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(stepStdout(ctx), "Drop-in %s of %s written\n", p.file, service.Name)
+	return &unitFileResult{File: p.file, Changed: true, Warnings: warnings}, nil
+}
+
+func (bt *BuildTool) planSystemctlDropIn(ctx context.Context, params interface{}) (*Action, error) {
+	p, paramMap, err := newDropInParams(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	switch p.op {
+	case DropInList:
+		return &Action{Description: fmt.Sprintf("list the drop-ins in %s", p.dir)}, nil
+	case DropInShow:
+		return &Action{Description: fmt.Sprintf("show the effective configuration of %s", p.unit)}, nil
+	case DropInRemove:
+		return &Action{Description: fmt.Sprintf("remove the drop-in %s", p.file), Files: []string{p.file}}, nil
+	}
+	_, content, _, err := dropInService(p, paramMap)
+	if err != nil {
+		return nil, err
+	}
+	return &Action{Description: fmt.Sprintf("write the drop-in %s of %s", p.file, p.unit), Files: []string{p.file}, Content: content}, nil
+}
+
+// dropInService builds the drop-in from the unit:, service: and install: params, like systemctl-file. As
+// systemd adds an ExecStart to the ones of the unit, the ExecStart of a drop-in resets them first.
+func dropInService(p *dropInParams, params map[string]interface{}) (*SystemctlService, string, []string, error) {
+	changes, err := unitChanges(params, false)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if len(changes) == 0 {
+		return nil, "", nil, fmt.Errorf("the drop-in has no directives, set them in unit:, service: or install:")
+	}
+	service := &SystemctlService{Name: p.unit}
+	for _, c := range changes {
+		values := c.values
+		if c.key == "ExecStart" && len(values) > 0 && values[0] != "" {
+			values = append([]string{""}, values...)
+		}
+		service.Set(c.section, c.key, values...)
+	}
+	content, err := service.Render()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return service, content, service.Warnings(), nil
+}
+
+// listDropIns returns the drop-ins in the .d dir of a unit, by name.
+func listDropIns(dir string) ([]dropIn, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	out := make([]dropIn, 0, len(files))
+	for _, file := range files {
+		u, err := commands.ReadUnitFile(file)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, dropIn{Name: strings.TrimSuffix(filepath.Base(file), ".conf"), File: file, Sections: sectionsOf(u)})
+	}
+	return out, nil
+}
+
+// effectiveUnit merges the unit file with its drop-ins the way systemd does. The unit file is the first one found
+// in the location then the unitSearchPaths, and the drop-ins are the .conf files of the .d dirs of the unit in all
+// of them, applied by file name, a drop-in in an earlier dir hiding one with the same name in a later one.
+func effectiveUnit(unit, location string) (*unitConfig, error) {
+	dirs := []string{location}
+	for _, dir := range unitSearchPaths {
+		if !contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	config := &unitConfig{Unit: unit}
+	var files []*commands.UnitFile
+	for _, dir := range dirs {
+		file := filepath.Join(dir, unit)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		u, err := commands.ReadUnitFile(file)
+		if err != nil {
+			return nil, err
+		}
+		config.Files = append(config.Files, file)
+		files = append(files, u)
+		break
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("unit %s not found in %s", unit, strings.Join(dirs, ", "))
+	}
+	dropIns := make(map[string]string)
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, unit+".d", "*.conf"))
+		for _, file := range matches {
+			if _, ok := dropIns[filepath.Base(file)]; !ok {
+				dropIns[filepath.Base(file)] = file
+			}
+		}
+	}
+	names := make([]string, 0, len(dropIns))
+	for name := range dropIns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		u, err := commands.ReadUnitFile(dropIns[name])
+		if err != nil {
+			return nil, err
+		}
+		config.Files = append(config.Files, dropIns[name])
+		files = append(files, u)
+	}
+	config.Sections = sectionsOf(commands.Merge(files...))
+	return config, nil
+}
+
+// sectionsOf returns the values of the directives of a unit file by section and key.
+func sectionsOf(u *commands.UnitFile) map[string]map[string][]string {
+	sections := make(map[string]map[string][]string)
+	for _, section := range u.Sections() {
+		directives := make(map[string][]string)
+		for _, d := range u.Directives(section) {
+			directives[d.Key] = append(directives[d.Key], d.Value)
+		}
+		sections[section] = directives
+	}
+	return sections
+}
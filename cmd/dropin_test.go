@@ -0,0 +1,114 @@
+package commander
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDropInParamsNames(t *testing.T) {
+	tests := []struct {
+		params map[string]interface{}
+		err    string
+	}{
+		{map[string]interface{}{"name": "driver", "dropin": "restart"}, ""},
+		{map[string]interface{}{"name": "driver.timer"}, ""},
+		{map[string]interface{}{"name": "../driver"}, `the name "../driver" must be a file name, without / or ..`},
+		{map[string]interface{}{"name": "system/driver"}, `the name "system/driver" must be a file name, without / or ..`},
+		{map[string]interface{}{"name": "driver", "dropin": "../../driver"}, `the dropin "../../driver" must be a file name, without / or ..`},
+		{map[string]interface{}{"name": "driver", "dropin": ".."}, `the dropin ".." must be a file name, without / or ..`},
+	}
+	for _, tt := range tests {
+		t.Run(paramString(tt.params, "name")+" "+paramString(tt.params, "dropin"), func(t *testing.T) {
+			_, _, err := newDropInParams(context.Background(), tt.params)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Fatalf("got error %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+// testUnitDirs swaps unitSearchPaths for temp dirs, and writes the files by their path relative to them, like
+// vendor/driver.service.
+func testUnitDirs(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	saved := unitSearchPaths
+	t.Cleanup(func() { unitSearchPaths = saved })
+	unitSearchPaths = []string{filepath.Join(root, "admin"), filepath.Join(root, "runtime"), filepath.Join(root, "vendor")}
+	for name, content := range files {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestEffectiveUnit(t *testing.T) {
+	root := testUnitDirs(t, map[string]string{
+		"vendor/driver.service":                   "[Service]\nExecStart=/opt/driver/old\nRestart=no\nUser=vendor\n",
+		"vendor/driver.service.d/override.conf":   "[Service]\nRestart=always\n",
+		"vendor/driver.service.d/10-env.conf":     "[Service]\nEnvironment=A=1\n",
+		"runtime/driver.service":                  "[Service]\nExecStart=/opt/driver/runtime\nRestart=on-failure\n",
+		"runtime/driver.service.d/20-user.conf":   "[Service]\nUser=rubix\n",
+		"admin/driver.service.d/override.conf":    "[Service]\nExecStart=\nExecStart=/opt/driver/new\n",
+		"admin/driver.service.d/30-more-env.conf": "[Service]\nEnvironment=B=2\n",
+	})
+	bt := NewBuildTool()
+	got, err := bt.handleSystemctlDropIn(context.Background(), map[string]interface{}{"op": DropInShow, "name": "driver", "location": filepath.Join(root, "admin")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := got.(*unitConfig)
+	// the unit file of the first dir that has one, and the drop-ins of every dir by name, the one of the
+	// first dir hiding the others with the same name
+	wantFiles := []string{
+		filepath.Join(root, "runtime/driver.service"),
+		filepath.Join(root, "vendor/driver.service.d/10-env.conf"),
+		filepath.Join(root, "runtime/driver.service.d/20-user.conf"),
+		filepath.Join(root, "admin/driver.service.d/30-more-env.conf"),
+		filepath.Join(root, "admin/driver.service.d/override.conf"),
+	}
+	if !reflect.DeepEqual(config.Files, wantFiles) {
+		t.Errorf("got files %v", config.Files)
+	}
+	want := map[string][]string{
+		// the empty ExecStart= resets the ones before it
+		"ExecStart":   {"/opt/driver/new"},
+		"Restart":     {"on-failure"},
+		"User":        {"rubix"},
+		"Environment": {"A=1", "B=2"},
+	}
+	if service := config.Sections[SectionService]; !reflect.DeepEqual(service, want) {
+		t.Errorf("got [Service] %v", service)
+	}
+
+	// a drop-in created by the step resets ExecStart the same way
+	_, err = bt.handleSystemctlDropIn(context.Background(), map[string]interface{}{
+		"name": "driver", "dropin": "override", "location": filepath.Join(root, "admin"),
+		"service": map[string]interface{}{"ExecStart": "/opt/driver/created"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err = effectiveUnit("driver.service", filepath.Join(root, "admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Sections[SectionService]["ExecStart"]; !reflect.DeepEqual(got, []string{"/opt/driver/created"}) {
+		t.Errorf("got ExecStart %v", got)
+	}
+
+	if _, err := effectiveUnit("missing.service", filepath.Join(root, "admin")); err == nil {
+		t.Error("expected an error for a missing unit")
+	}
+}
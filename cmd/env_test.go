@@ -0,0 +1,92 @@
+package commander
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvWorkdir(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"flows/sub", "work/step", "work/called"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// each step writes its working dir and env to a file named like the step
+	write := func(name string) string {
+		return `pwd -P > "${out}/` + name + `" && echo "$GREETING $TARGET" >> "${out}/` + name + `"`
+	}
+	files := map[string]string{
+		"flows/main.yaml": `
+args: [out]
+workdir: ../work
+env: {GREETING: hello, TARGET: flow}
+steps:
+  - {name: flow, cmd: bash, params: '` + write("flow") + `'}
+  - {name: step, cmd: bash, workdir: step, env: {TARGET: step}, params: '` + write("step") + `'}
+  - {name: absolute, cmd: bash, workdir: ` + dir + `, params: '` + write("absolute") + `'}
+  - {name: call, cmd: flow, workdir: called, env: {TARGET: caller}, params: {file: sub/child.yaml, out: "${out}"}}
+  - {name: call with workdir, cmd: flow, workdir: called, params: {file: sub/own.yaml, out: "${out}"}}
+`,
+		"flows/sub/child.yaml": `
+args: [out]
+steps:
+  - {name: child, cmd: bash, params: '` + write("child") + `'}
+`,
+		"flows/sub/own.yaml": `
+args: [out]
+workdir: .
+env: {TARGET: own}
+steps:
+  - {name: own, cmd: bash, params: '` + write("own") + `'}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := t.TempDir()
+
+	bt := NewBuildTool()
+	flow, err := bt.LoadBuildYAML(filepath.Join(dir, "flows/main.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := Failure(bt.RunFlow(context.Background(), flow, map[string]string{"out": out})); failed != nil {
+		t.Fatalf("step %s failed: %s", failed.Name, failed.Error)
+	}
+	tests := []struct {
+		step    string
+		workdir string
+		env     string
+	}{
+		// the workdir of the flow is relative to the flow file
+		{"flow", "work", "hello flow"},
+		// the workdir of a step is relative to the workdir of the flow, its env is added to the env of the flow
+		{"step", "work/step", "hello step"},
+		{"absolute", ".", "hello flow"},
+		// a sub-flow runs in the workdir and env of the step that runs it
+		{"child", "work/called", "hello caller"},
+		// unless it has its own, relative to its own file
+		{"own", "flows/sub", "hello own"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.step, func(t *testing.T) {
+			b, err := os.ReadFile(filepath.Join(out, tt.step))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+			if want := filepath.Join(dir, tt.workdir); len(lines) != 2 || lines[0] != want || lines[1] != tt.env {
+				t.Errorf("got %q, want %s and %s", lines, want, tt.env)
+			}
+		})
+	}
+}
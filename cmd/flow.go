@@ -0,0 +1,254 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/expr"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxFlowDepth is how deep flows can call, or include, other flows.
+const maxFlowDepth = 8
+
+// flowStackKey is the context key of the files of the flows being run, outermost first.
+type flowStackKey struct{}
+
+// flowStack returns the files of the flows that ctx is running in.
+func flowStack(ctx context.Context) []string {
+	stack, _ := ctx.Value(flowStackKey{}).([]string)
+	return stack
+}
+
+// withFlow returns a context for running the flow loaded from file, inside the flows of ctx.
+func withFlow(ctx context.Context, file string) context.Context {
+	if file == "" {
+		return ctx
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	stack := flowStack(ctx)
+	return context.WithValue(ctx, flowStackKey{}, append(stack[:len(stack):len(stack)], file))
+}
+
+// checkFlowStack returns an error if the flow file can't be entered from the stack, because it is already
+// in it or the stack is too deep.
+func checkFlowStack(stack []string, file, verb string) error {
+	chain := func() string {
+		names := make([]string, 0, len(stack)+1)
+		for _, f := range stack {
+			names = append(names, filepath.Base(f))
+		}
+		return strings.Join(append(names, filepath.Base(file)), " "+verb+" ")
+	}
+	for _, f := range stack {
+		if f == file {
+			return fmt.Errorf("flows have a cycle: %s", chain())
+		}
+	}
+	if len(stack) >= maxFlowDepth {
+		return fmt.Errorf("flows are nested more than %d deep: %s", maxFlowDepth, chain())
+	}
+	return nil
+}
+
+// relativeTo resolves the file of an include or a flow step against the dir of the flow it is used in.
+func relativeTo(from, file string) string {
+	if filepath.IsAbs(file) || from == "" {
+		return file
+	}
+	return filepath.Join(filepath.Dir(from), file)
+}
+
+// loadFlowFile reads a flow file and merges in the flows it includes, stack is the files including it.
+func loadFlowFile(filename string, stack []string) (*BuildYAML, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFlowStack(stack, abs, "includes"); err != nil {
+		return nil, err
+	}
+	yamlFile, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var buildYAML BuildYAML
+	if err := yaml.Unmarshal(yamlFile, &buildYAML); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	buildYAML.File = filename
+	// the included flows are merged in order, before the flow itself
+	merged := BuildYAML{}
+	for _, include := range buildYAML.Include {
+		included, err := loadFlowFile(relativeTo(filename, include), append(stack, abs))
+		if err != nil {
+			return nil, err
+		}
+		merged.merge(included)
+	}
+	if len(buildYAML.Include) == 0 {
+		return &buildYAML, nil
+	}
+	merged.merge(&buildYAML)
+	buildYAML.Args = merged.Args
+	buildYAML.Vars = merged.Vars
+	buildYAML.Steps = merged.Steps
+	buildYAML.Finally = merged.Finally
+	buildYAML.Always = merged.Always
+	if buildYAML.Outputs == nil {
+		buildYAML.Outputs = merged.Outputs
+	}
+	return &buildYAML, nil
+}
+
+// merge adds the args, vars, steps and outputs of other after those of b. An arg, var or output of other replaces
+// the one of b with the same name.
+func (b *BuildYAML) merge(other *BuildYAML) {
+	for _, arg := range other.Args {
+		replaced := false
+		for i := range b.Args {
+			if b.Args[i].Name == arg.Name {
+				b.Args[i] = arg
+				replaced = true
+			}
+		}
+		if !replaced {
+			b.Args = append(b.Args, arg)
+		}
+	}
+	for _, v := range other.Vars {
+		replaced := false
+		for i := range b.Vars {
+			if b.Vars[i].Name == v.Name {
+				b.Vars[i] = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			b.Vars = append(b.Vars, v)
+		}
+	}
+	b.Steps = append(b.Steps, other.Steps...)
+	b.Finally = append(b.Finally, other.Finally...)
+	b.Always = append(b.Always, other.Always...)
+	for name, output := range other.Outputs {
+		if b.Outputs == nil {
+			b.Outputs = make(map[string]string)
+		}
+		b.Outputs[name] = output
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// outputs resolves the outputs: of the flow once its steps have run. An output that is a single ${...}
+// keeps the type of its value, so a sub-flow can return a list or a map.
+func (r *flowRun) outputs() (map[string]interface{}, error) {
+	if len(r.flow.Outputs) == 0 {
+		return nil, nil
+	}
+	scope := r.scope()
+	outputs := make(map[string]interface{}, len(r.flow.Outputs))
+	for name, output := range r.flow.Outputs {
+		v, err := r.bt.template().Render(output, scope)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %v", name, err)
+		}
+		outputs[name] = v
+	}
+	return outputs, nil
+}
+
+// flowResult is the response of a flow step.
+type flowResult struct {
+	File    string                 `json:"file"`
+	Name    string                 `json:"name,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Steps   []*Response            `json:"steps"`
+}
+
+// handleFlow runs another flow file, params are its file, relative to the calling flow, and its args:
+//
+//	cmd: flow
+//	params:
+//	  file: install-driver.yaml
+//	  driver: ${driver}
+//
+// The step fails if the flow fails, otherwise its response has the outputs: of the flow.
+func (bt *BuildTool) handleFlow(ctx context.Context, params interface{}) (interface{}, error) {
+	file, r, err := bt.runSubFlow(ctx, params, false)
+	if err != nil {
+		return nil, err
+	}
+	if failed := Failure(r.all); failed != nil {
+		return nil, fmt.Errorf("flow %s failed at step %q: %s", file, failed.Name, failed.Error)
+	}
+	outputs, err := r.outputs()
+	if err != nil {
+		return nil, fmt.Errorf("flow %s: %v", file, err)
+	}
+	for name, val := range outputs {
+		outputs[name] = r.redact.value(val)
+	}
+	return &flowResult{File: file, Name: r.flow.Name, Outputs: outputs, Steps: r.all}, nil
+}
+
+// planFlow plans the steps of the flow a flow step would run.
+func (bt *BuildTool) planFlow(ctx context.Context, params interface{}) (*Action, error) {
+	file, r, err := bt.runSubFlow(ctx, params, true)
+	if err != nil {
+		return nil, err
+	}
+	if failed := Failure(r.all); failed != nil {
+		return nil, fmt.Errorf("flow %s failed at step %q: %s", file, failed.Name, failed.Error)
+	}
+	return &Action{Description: fmt.Sprintf("run the flow %s", file), Files: []string{file}, Steps: r.all}, nil
+}
+
+// runSubFlow runs the flow of the params of a flow step, or plans it when dryRun is set.
+func (bt *BuildTool) runSubFlow(ctx context.Context, params interface{}, dryRun bool) (string, *flowRun, error) {
+	p, ok := params.(map[string]interface{})
+	if !ok {
+		return "", nil, fmt.Errorf("invalid params for flow, expected a map with a file and the args of the flow")
+	}
+	file, _ := p["file"].(string)
+	if file == "" {
+		return "", nil, fmt.Errorf("flow requires a file")
+	}
+	stack := flowStack(ctx)
+	if len(stack) > 0 {
+		file = relativeTo(stack[len(stack)-1], file)
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkFlowStack(stack, abs, "calls"); err != nil {
+		return "", nil, err
+	}
+	args := make(map[string]string)
+	for key, val := range p {
+		if key != "file" {
+			args[key] = expr.ToString(val)
+		}
+	}
+
+	// the sub-flow has its own vars, and is recorded as part of the step that runs it
+	sub := &BuildTool{CommandMap: bt.CommandMap, Commands: bt.Commands, DryRun: dryRun, Secrets: bt.Secrets, ArgFiles: bt.ArgFiles, system: bt.system, systemd: bt.systemd}
+	buildYAML, err := sub.LoadBuildYAML(file)
+	if err != nil {
+		return "", nil, err
+	}
+	return file, sub.run(ctx, buildYAML, args), nil
+}
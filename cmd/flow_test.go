@@ -0,0 +1,196 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFlows writes the flow files by name to a temp dir and returns the dir.
+func writeFlows(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// chainedFlows returns n flow files f0.yaml to f<n-1>.yaml, each one including, or calling with a flow step,
+// the next.
+func chainedFlows(n int, include bool) map[string]string {
+	files := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		content := fmt.Sprintf("steps:\n  - {name: step %d, cmd: ok}\n", i)
+		if i < n-1 && include {
+			content = fmt.Sprintf("include: [f%d.yaml]\n%s", i+1, content)
+		} else if i < n-1 {
+			content += fmt.Sprintf("  - {name: call %d, cmd: flow, params: {file: f%d.yaml}}\n", i+1, i+1)
+		}
+		files[fmt.Sprintf("f%d.yaml", i)] = content
+	}
+	return files
+}
+
+func stepNames(steps []BuildStep) string {
+	names := make([]string, len(steps))
+	for i, step := range steps {
+		names[i] = step.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestLoadFlowFileIncludes(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name:  "self",
+			files: map[string]string{"f0.yaml": "include: [f0.yaml]"},
+			err:   "flows have a cycle: f0.yaml includes f0.yaml",
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"f0.yaml": "include: [a.yaml]",
+				"a.yaml":  "include: [b.yaml]",
+				"b.yaml":  "include: [a.yaml]",
+			},
+			err: "flows have a cycle: f0.yaml includes a.yaml includes b.yaml includes a.yaml",
+		},
+		{
+			name:  "8 deep",
+			files: chainedFlows(maxFlowDepth, true),
+		},
+		{
+			name:  "9 deep",
+			files: chainedFlows(maxFlowDepth+1, true),
+			err: "flows are nested more than 8 deep: f0.yaml includes f1.yaml includes f2.yaml includes f3.yaml " +
+				"includes f4.yaml includes f5.yaml includes f6.yaml includes f7.yaml includes f8.yaml",
+		},
+		{
+			name:  "missing",
+			files: map[string]string{"f0.yaml": "include: [missing.yaml]"},
+			err:   "/missing.yaml: no such file or directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFlows(t, tt.files)
+			flow, err := loadFlowFile(filepath.Join(dir, "f0.yaml"), nil)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(flow.Steps) != len(tt.files) {
+					t.Errorf("got %d steps", len(flow.Steps))
+				}
+				return
+			}
+			if err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadFlowFileMerge(t *testing.T) {
+	dir := writeFlows(t, map[string]string{
+		"common.yaml": `
+args: [owner, {name: arch, default: armv7}]
+vars:
+  - {name: version, value: 1}
+  - {name: dir, value: /data}
+steps:
+  - {name: common, cmd: ok}
+finally:
+  - {name: common cleanup, cmd: ok}
+outputs:
+  path: ${dir}
+  version: ${version}
+`,
+		"flow.yaml": `
+include: [common.yaml]
+args: [{name: arch, default: amd64}, tag]
+vars:
+  - {name: version, value: 2}
+steps:
+  - {name: own, cmd: ok}
+finally:
+  - {name: own cleanup, cmd: ok}
+outputs:
+  version: v${version}
+`,
+	})
+	flow, err := loadFlowFile(filepath.Join(dir, "flow.yaml"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := flow.Args, []Arg{{Name: "owner"}, {Name: "arch", Default: "amd64"}, {Name: "tag"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got args %+v", got)
+	}
+	if got, want := flow.Vars, []Variable{{Name: "version", Value: 2}, {Name: "dir", Value: "/data"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got vars %+v", got)
+	}
+	if got := stepNames(flow.Steps); got != "common, own" {
+		t.Errorf("got steps %v", got)
+	}
+	if got := stepNames(flow.Finally); got != "common cleanup, own cleanup" {
+		t.Errorf("got finally %v", got)
+	}
+	// an outputs: of the flow replaces those of the includes
+	if got, want := flow.Outputs, map[string]string{"version": "v${version}"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got outputs %v", got)
+	}
+}
+
+func TestFlowStepCycle(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name:  "self",
+			files: map[string]string{"f0.yaml": "steps:\n  - {name: again, cmd: flow, params: {file: f0.yaml}}"},
+			err:   "flows have a cycle: f0.yaml calls f0.yaml",
+		},
+		{
+			name:  "8 deep",
+			files: chainedFlows(maxFlowDepth, false),
+		},
+		{
+			name:  "9 deep",
+			files: chainedFlows(maxFlowDepth+1, false),
+			err:   "flows are nested more than 8 deep: f0.yaml calls f1.yaml calls f2.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFlows(t, tt.files)
+			bt := testTool()
+			flow, err := bt.LoadBuildYAML(filepath.Join(dir, "f0.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			failed := Failure(bt.RunFlow(context.Background(), flow, nil))
+			if tt.err == "" {
+				if failed != nil {
+					t.Fatalf("step %s failed: %s", failed.Name, failed.Error)
+				}
+				return
+			}
+			if failed == nil || !strings.Contains(failed.Error, tt.err) {
+				t.Fatalf("got %+v, want the error %s", failed, tt.err)
+			}
+		})
+	}
+}
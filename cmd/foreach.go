@@ -0,0 +1,92 @@
+package commander
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// executeForeach runs the step once for each of its foreach: items, with ${item} and ${index} set in the params.
+// Each item is recorded in out.Iterations, the response of the step is the list of their responses, and the step
+// stops at the first item that fails.
+func (r *flowRun) executeForeach(ctx context.Context, out *Response, step BuildStep) {
+	items, err := r.foreachItems(step.Foreach)
+	if err != nil {
+		out.Status = StatusFailed
+		out.Error = err.Error()
+		return
+	}
+	if r.bt.DryRun {
+		out.Plan = &Action{Description: fmt.Sprintf("run %s for each of %d items", step.Cmd, len(items))}
+	}
+	responses := make([]interface{}, 0, len(items))
+	for index, item := range items {
+		startedAt := time.Now()
+		iteration := &Response{
+			Name:      fmt.Sprintf("%s[%d]", step.Name, index),
+			Cmd:       step.Cmd,
+			StepCount: index,
+			Status:    StatusRunning,
+			StartedAt: &startedAt,
+		}
+		out.Iterations = append(out.Iterations, iteration)
+		scope := r.scope()
+		scope["item"] = item
+		scope["index"] = index
+		r.execute(ctx, iteration, step, scope)
+		finishedAt := time.Now()
+		iteration.FinishedAt = &finishedAt
+		if iteration.Status == StatusFailed {
+			out.Status = StatusFailed
+			out.Error = fmt.Sprintf("item %d failed: %s", index, iteration.Error)
+			return
+		}
+		iteration.Status = StatusSucceeded
+		if r.bt.DryRun {
+			iteration.Status = StatusPlanned
+		}
+		responses = append(responses, iteration.Response)
+	}
+	if !r.bt.DryRun {
+		out.Response = responses
+	}
+}
+
+// foreachItems resolves the foreach: of a step to its items. It is either a list, whose items can use ${...}, or a
+// ${...} reference to a list. A reference to a string is read as a JSON list, or else as a comma separated list,
+// so that an arg like drivers=bacnet,modbus,lora can be looped over.
+func (r *flowRun) foreachItems(foreach interface{}) ([]interface{}, error) {
+	scope := r.scope()
+	switch f := foreach.(type) {
+	case []interface{}:
+		items, err := resolveParams(r.bt.template(), f, scope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid foreach: %v", err)
+		}
+		return items.([]interface{}), nil
+	case string:
+		v, err := r.bt.template().Render(strings.TrimSpace(f), scope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid foreach %q: %v", f, err)
+		}
+		switch t := v.(type) {
+		case []interface{}:
+			return t, nil
+		case string:
+			var items []interface{}
+			if err := json.Unmarshal([]byte(t), &items); err == nil {
+				return items, nil
+			}
+			for _, item := range strings.Split(t, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			return items, nil
+		}
+		return nil, fmt.Errorf("foreach %q is not a list", f)
+	}
+	return nil, fmt.Errorf("foreach must be a list or a ${...} reference to one")
+}
@@ -0,0 +1,121 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestForeach(t *testing.T) {
+	tests := []struct {
+		name       string
+		flow       string
+		args       map[string]string
+		want       []interface{}
+		iterations int
+		err        string
+	}{
+		{
+			name: "literal list",
+			flow: `
+vars: [{name: suffix, value: "-driver"}]
+steps:
+  - {name: each, cmd: ok, foreach: [bacnet, "modbus${suffix}"], params: "${index}:${item}"}`,
+			want:       []interface{}{"0:bacnet", "1:modbus-driver"},
+			iterations: 2,
+		},
+		{
+			name: "var list",
+			flow: `
+vars: [{name: drivers, value: [bacnet, modbus, lora]}]
+steps:
+  - {name: each, cmd: ok, foreach: "${drivers}", params: "${item}"}`,
+			want:       []interface{}{"bacnet", "modbus", "lora"},
+			iterations: 3,
+		},
+		{
+			name: "comma separated arg",
+			flow: `
+args: [drivers]
+steps:
+  - {name: each, cmd: ok, foreach: "${drivers}", params: "${item}"}`,
+			args:       map[string]string{"drivers": "bacnet, modbus,,lora"},
+			want:       []interface{}{"bacnet", "modbus", "lora"},
+			iterations: 3,
+		},
+		{
+			name: "JSON list arg",
+			flow: `
+args: [ports]
+steps:
+  - {name: each, cmd: ok, foreach: "${ports}", params: "${item}"}`,
+			args:       map[string]string{"ports": "[1660, 1661]"},
+			want:       []interface{}{float64(1660), float64(1661)},
+			iterations: 2,
+		},
+		{
+			name: "map items",
+			flow: `
+vars:
+  - name: drivers
+    value:
+      - {repo: driver-bacnet, port: 1660}
+      - {repo: driver-modbus, port: 1661}
+steps:
+  - {name: each, cmd: ok, foreach: "${drivers}", params: {repo: "${item.repo}", port: "${item.port}"}}`,
+			want: []interface{}{
+				map[string]interface{}{"repo": "driver-bacnet", "port": 1660},
+				map[string]interface{}{"repo": "driver-modbus", "port": 1661},
+			},
+			iterations: 2,
+		},
+		{
+			name: "stops at the first failing item",
+			flow: `
+steps:
+  - {name: each, cmd: failOn, foreach: [a, bad, c, bad], params: "${item}"}`,
+			iterations: 2,
+			err:        "item 1 failed: bad item",
+		},
+		{
+			name: "not a list",
+			flow: `
+vars: [{name: count, value: 3}]
+steps:
+  - {name: each, cmd: ok, foreach: "${count}", params: "${item}"}`,
+			err: `foreach "${count}" is not a list`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bt := testTool()
+			bt.CommandMap["failOn"] = func(_ context.Context, params interface{}) (interface{}, error) {
+				if params == "bad" {
+					return nil, fmt.Errorf("bad item")
+				}
+				return params, nil
+			}
+			resp := bt.RunFlow(context.Background(), testFlow(t, tt.flow), tt.args)
+			if len(resp) != 1 {
+				t.Fatalf("got %d responses", len(resp))
+			}
+			out := resp[0]
+			if len(out.Iterations) != tt.iterations {
+				t.Errorf("got %d iterations, want %d", len(out.Iterations), tt.iterations)
+			}
+			if tt.err != "" {
+				if out.Status != StatusFailed || out.Error != tt.err {
+					t.Errorf("got %s with error %q, want %s", out.Status, out.Error, tt.err)
+				}
+				return
+			}
+			if out.Status != StatusSucceeded {
+				t.Fatalf("got %s: %s", out.Status, out.Error)
+			}
+			if got, ok := out.Response.([]interface{}); !ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", out.Response, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package commander
 
 import (
 	"archive/zip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/go-resty/resty/v2"
@@ -11,23 +12,106 @@ import (
 	"strings"
 )
 
-func (bt *BuildTool) handleGitHubDownload(params interface{}) (interface{}, error) {
+// githubDownload is the response of the github-download command.
+type githubDownload struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	URL      string `json:"url"`
+	Unzipped string `json:"unzipped,omitempty"` // the dir the zip was unzipped to, see githubOptions
+}
+
+// githubOptions are the options: of the github-download command.
+type githubOptions struct {
+	Asset string // a pattern like *armv7*.zip the name of the asset must match, instead of containing the arch
+	Unzip string // a dir to unzip the downloaded asset to
+}
+
+func parseGithubOptions(paramMap map[string]interface{}) (githubOptions, error) {
+	var opts githubOptions
+	switch options := paramMap["options"].(type) {
+	case nil:
+	case map[string]interface{}:
+		opts.Asset = paramString(options, "asset")
+		opts.Unzip = paramString(options, "unzip")
+		if opts.Asset != "" {
+			if _, err := filepath.Match(opts.Asset, ""); err != nil {
+				return opts, fmt.Errorf("invalid asset pattern %q: %v", opts.Asset, err)
+			}
+		}
+	default:
+		return opts, fmt.Errorf("options of github-download must be a map")
+	}
+	return opts, nil
+}
+
+// match reports whether the asset is the one to download.
+func (o githubOptions) match(name, arch string) bool {
+	if o.Asset != "" {
+		ok, _ := filepath.Match(o.Asset, name)
+		return ok
+	}
+	return strings.Contains(name, arch)
+}
+
+func (bt *BuildTool) planGitHubDownload(ctx context.Context, params interface{}) (*Action, error) {
 	paramMap, ok := params.(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("invalid params for GitHub download")
 	}
+	owner := paramString(paramMap, "owner")
+	repo := paramString(paramMap, "repo")
+	tag := paramString(paramMap, "tag")
+	arch := paramString(paramMap, "arch")
+	downloadDir := paramString(paramMap, "location")
+	if downloadDir == "" {
+		downloadDir = "./"
+	}
+	downloadDir = resolvePath(ctx, downloadDir)
+	opts, err := parseGithubOptions(paramMap)
+	if err != nil {
+		return nil, err
+	}
+	opts.Unzip = resolvePath(ctx, opts.Unzip)
+	asset := arch
+	if opts.Asset != "" {
+		asset = opts.Asset
+	}
+	action := &Action{
+		Description: fmt.Sprintf("download the %s zip of the %s/%s release %s to %s", asset, owner, repo, tag, downloadDir),
+		URL:         fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/tags/%s", owner, repo, tag),
+		Files:       []string{downloadDir},
+	}
+	if opts.Unzip != "" {
+		action.Description += fmt.Sprintf(" and unzip it to %s", opts.Unzip)
+		action.Files = append(action.Files, opts.Unzip)
+	}
+	return action, nil
+}
 
-	owner, _ := paramMap["owner"].(string)
-	repo, _ := paramMap["repo"].(string)
-	tag, _ := paramMap["tag"].(string)
-	arch, _ := paramMap["arch"].(string)
-	token, _ := paramMap["token"].(string)
-	downloadDir, _ := paramMap["location"].(string)
+func (bt *BuildTool) handleGitHubDownload(ctx context.Context, params interface{}) (interface{}, error) {
+	paramMap, ok := params.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid params for GitHub download")
+	}
+
+	owner := paramString(paramMap, "owner")
+	repo := paramString(paramMap, "repo")
+	tag := paramString(paramMap, "tag")
+	arch := paramString(paramMap, "arch")
+	token := paramString(paramMap, "token")
+	downloadDir := paramString(paramMap, "location")
 	if downloadDir == "" {
 		downloadDir = "./"
 	}
+	downloadDir = resolvePath(ctx, downloadDir)
+	opts, err := parseGithubOptions(paramMap)
+	if err != nil {
+		return nil, err
+	}
+	opts.Unzip = resolvePath(ctx, opts.Unzip)
 	client := resty.New()
 	resp, err := client.R().
+		SetContext(ctx).
 		SetHeader("Authorization", fmt.Sprintf("token %s", token)).
 		Get(fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/tags/%s", owner, repo, tag))
 	if err != nil {
@@ -48,26 +132,43 @@ func (bt *BuildTool) handleGitHubDownload(params interface{}) (interface{}, erro
 		for _, asset := range assets {
 			assetInfo := asset.(map[string]interface{})
 			name := assetInfo["name"].(string)
-			if strings.Contains(name, arch) {
+			if opts.match(name, arch) {
 				// Download the release zip file
 				zipFilePath := filepath.Join(downloadDir, name)
+				url := assetInfo["browser_download_url"].(string)
 				resp, err := client.R().
+					SetContext(ctx).
 					SetHeader("Authorization", fmt.Sprintf("token %s", token)).
 					SetOutput(zipFilePath).
-					Get(assetInfo["browser_download_url"].(string))
+					Get(url)
 				if err != nil {
+					os.Remove(zipFilePath)
 					return nil, fmt.Errorf("failed to download release zip: %v", err)
 				}
 				defer resp.RawResponse.Body.Close()
-				bt.UpdateVar("zipName", zipFilePath)
-				fmt.Printf("Release successfully downloaded to: %s\n", zipFilePath)
-				return nil, nil
+				// the body of an error response is written to the file too, it isn't the zip
+				if resp.IsError() {
+					os.Remove(zipFilePath)
+					return nil, fmt.Errorf("failed to download release zip %s: GET %s returned %s", name, url, resp.Status())
+				}
+				fmt.Fprintf(stepStdout(ctx), "Release successfully downloaded to: %s\n", zipFilePath)
+				download := &githubDownload{Name: name, Path: zipFilePath, URL: url}
+				if opts.Unzip != "" {
+					if err := unzip(zipFilePath, opts.Unzip); err != nil {
+						return nil, fmt.Errorf("failed to unzip %s: %v", zipFilePath, err)
+					}
+					download.Unzipped = opts.Unzip
+				}
+				return download, nil
 			}
 		}
 	} else {
 		return nil, fmt.Errorf("no assets found in the release information")
 	}
 
+	if opts.Asset != "" {
+		return nil, fmt.Errorf("no asset matching %s found", opts.Asset)
+	}
 	return nil, fmt.Errorf("no matching zip file found for architecture: %s", arch)
 }
 
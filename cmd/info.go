@@ -1,10 +1,11 @@
 package commander
 
 import (
+	"context"
 	"fmt"
 )
 
-func (bt *BuildTool) handleSystemInfo(params interface{}) (interface{}, error) {
+func (bt *BuildTool) handleSystemInfo(_ context.Context, params interface{}) (interface{}, error) {
 	ops, err := parseParams(params)
 	if err != nil {
 		return nil, fmt.Errorf("system information: %v", err)
@@ -18,14 +19,7 @@ func parseParams(params interface{}) (operations []string, err error) {
 	case []string:
 		paramList = p
 	case []interface{}:
-		// Try to convert []interface{} to []string
-		for _, v := range p {
-			if str, ok := v.(string); ok {
-				paramList = append(paramList, str)
-			} else {
-				return nil, fmt.Errorf("invalid element type in []interface{}")
-			}
-		}
+		paramList = paramStrings(p)
 	default:
 		return nil, fmt.Errorf("invalid params type")
 	}
@@ -1,9 +1,10 @@
 package commander
 
 import (
+	"context"
 	"time"
 )
 
-func (bt *BuildTool) time(params interface{}) (interface{}, error) {
+func (bt *BuildTool) time(_ context.Context, params interface{}) (interface{}, error) {
 	return time.Now(), nil
 }
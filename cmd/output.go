@@ -0,0 +1,143 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/events"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultLogDir returns the dir the per-run log files are written to, BIOS_LOGS_DIR or ./logs.
+func DefaultLogDir() string {
+	if dir := os.Getenv("BIOS_LOGS_DIR"); dir != "" {
+		return dir
+	}
+	return "logs"
+}
+
+// runLog is the log file of a single run, shared by all its steps.
+type runLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+}
+
+// openRunLog creates the log file for the run in bt.LogDir, it returns nil when there is no LogDir.
+func (bt *BuildTool) openRunLog(runID string) (*runLog, error) {
+	if bt.LogDir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(bt.LogDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log dir %s: %v", bt.LogDir, err)
+	}
+	path := filepath.Join(bt.LogDir, fmt.Sprintf("%s.log", runID))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run log %s: %v", path, err)
+	}
+	return &runLog{f: f, path: path}, nil
+}
+
+func (l *runLog) write(e events.Event) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e.Type == events.TypeStep {
+		fmt.Fprintf(l.f, "%s [%d %s] %s\n", e.Time.Format(time.RFC3339), e.Index, e.Step, e.Status)
+		return
+	}
+	fmt.Fprintf(l.f, "%s [%d %s] %s: %s\n", e.Time.Format(time.RFC3339), e.Index, e.Step, e.Stream, e.Line)
+}
+
+func (l *runLog) close() {
+	if l == nil {
+		return
+	}
+	l.f.Close()
+}
+
+// stepOutput captures the stdout and stderr of a step as line events.
+type stepOutput struct {
+	stdout *events.LineWriter
+	stderr *events.LineWriter
+}
+
+// newStepOutput creates the writers for a step, each line goes to bt.Events, the run log and os.Stderr,
+// so it never gets mixed in with the JSON response on os.Stdout. The secrets are redacted from every line.
+// When the flow was run by a flow step, the lines go to the output of that step instead, prefixed with the
+// name of the step.
+func (bt *BuildTool) newStepOutput(ctx context.Context, runID string, index int, name string, log *runLog, redact *redactor) *stepOutput {
+	_, nested := ctx.Value(outputKey{}).(*stepOutput)
+	writer := func(stream string) *events.LineWriter {
+		echo := stepStdout(ctx)
+		if stream == "stderr" {
+			echo = stepStderr(ctx)
+		}
+		return events.NewLineWriter(func(line string) {
+			line = redact.string(line)
+			bt.publish(log, events.Event{
+				Type:   events.TypeLine,
+				RunID:  runID,
+				Index:  index,
+				Step:   name,
+				Stream: stream,
+				Line:   line,
+			})
+			if nested {
+				fmt.Fprintf(echo, "[%s] %s\n", name, line)
+			} else {
+				fmt.Fprintln(echo, line)
+			}
+		})
+	}
+	return &stepOutput{
+		stdout: writer("stdout"),
+		stderr: writer("stderr"),
+	}
+}
+
+// outputKey is the context key of the stepOutput of the running step.
+type outputKey struct{}
+
+// withOutput returns a context carrying the output of the step, for its handler.
+func (o *stepOutput) withOutput(ctx context.Context) context.Context {
+	return context.WithValue(ctx, outputKey{}, o)
+}
+
+// stepStdout is where a handler writes its stdout, os.Stderr when run outside of RunFlow.
+func stepStdout(ctx context.Context) io.Writer {
+	if o, ok := ctx.Value(outputKey{}).(*stepOutput); ok {
+		return o.stdout
+	}
+	return os.Stderr
+}
+
+// stepStderr is where a handler writes its stderr.
+func stepStderr(ctx context.Context) io.Writer {
+	if o, ok := ctx.Value(outputKey{}).(*stepOutput); ok {
+		return o.stderr
+	}
+	return os.Stderr
+}
+
+func (o *stepOutput) flush() {
+	o.stdout.Flush()
+	o.stderr.Flush()
+}
+
+// publish sends the event to bt.Events and the run log.
+func (bt *BuildTool) publish(log *runLog, e events.Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if bt.Events != nil {
+		bt.Events.Publish(e)
+	}
+	log.write(e)
+}
@@ -0,0 +1,74 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/events"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestStepOutput(t *testing.T) {
+	dir := writeFlows(t, map[string]string{
+		"main.yaml": `
+args: [{name: token, secret: true}]
+steps:
+  - {name: prepare, cmd: ok}
+  - {name: build, cmd: bash, params: "printf 'compiling\nlinking %s\npartial' ${token}; echo oops >&2"}
+  - {name: call, cmd: flow, params: {file: child.yaml}}
+`,
+		"child.yaml": `
+steps:
+  - {name: child, cmd: bash, params: "echo nested"}
+`,
+	})
+	bt := testTool()
+	bt.Events = events.NewBroker()
+	bt.LogDir = t.TempDir()
+	flow, err := bt.LoadBuildYAML(filepath.Join(dir, "main.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := Failure(bt.RunFlow(context.Background(), flow, map[string]string{"token": "hunter2"})); failed != nil {
+		t.Fatalf("step %s failed: %s", failed.Name, failed.Error)
+	}
+
+	history, _, _ := bt.Events.Subscribe()
+	// stdout and stderr are read apart, so only the order of the lines of each is known
+	lines := make(map[string][]string)
+	for _, e := range history {
+		if e.Type == events.TypeLine {
+			lines[e.Stream] = append(lines[e.Stream], fmt.Sprintf("%d %s: %s", e.Index, e.Step, e.Line))
+		}
+	}
+	// the partial last line is flushed when the step ends, and the lines of a sub-flow are prefixed with its step
+	want := map[string][]string{
+		"stdout": {
+			"1 build: compiling",
+			"1 build: linking ***",
+			"1 build: partial",
+			"2 call: [child] nested",
+		},
+		"stderr": {"1 build: oops"},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got lines\n%q\nwant\n%q", lines, want)
+	}
+
+	logs, _ := filepath.Glob(filepath.Join(bt.LogDir, "*.log"))
+	if len(logs) != 1 {
+		t.Fatalf("got logs %v", logs)
+	}
+	b, err := os.ReadFile(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{`\[1 build\] stdout: linking \*\*\*`, `\[1 build\] stderr: oops`, `\[2 call\] stdout: \[child\] nested`, `\[1 build\] succeeded`} {
+		if !regexp.MustCompile(`(?m)^\S+ ` + line + `$`).Match(b) {
+			t.Errorf("the log has no line %s:\n%s", line, b)
+		}
+	}
+}
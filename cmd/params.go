@@ -0,0 +1,60 @@
+package commander
+
+import (
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/expr"
+	"strings"
+)
+
+// ParseArgs parses CLI args in the form key=value into a map.
+func ParseArgs(rawArgs []string) map[string]string {
+	args := make(map[string]string)
+	for _, arg := range rawArgs {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) == 2 {
+			args[parts[0]] = parts[1]
+		}
+	}
+	return args
+}
+
+// resolveParams resolves the ${...} references in every string of the params, however deeply nested, and keeps
+// the type of everything else. A string that is a single reference takes the type of its value.
+func resolveParams(tmpl *expr.Template, params interface{}, scope expr.Scope) (interface{}, error) {
+	switch p := params.(type) {
+	case string:
+		return tmpl.Render(p, scope)
+	case []interface{}:
+		resolved := make([]interface{}, len(p))
+		for i, param := range p {
+			v, err := resolveParams(tmpl, param, scope)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = v
+		}
+		return resolved, nil
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(p))
+		for key, param := range p {
+			v, err := resolveParams(tmpl, param, scope)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			// keys can be templates too, like a header named after an arg
+			k, err := tmpl.Render(key, scope)
+			if err != nil {
+				return nil, err
+			}
+			resolved[expr.ToString(k)] = v
+		}
+		return resolved, nil
+	}
+	return params, nil
+}
+
+// paramString returns a param of map params as a string, whatever its type, so that tag: 1.2 or port: ${port}
+// work where a string is expected. It is "" when the param is missing.
+func paramString(params map[string]interface{}, key string) string {
+	return expr.ToString(params[key])
+}
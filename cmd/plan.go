@@ -0,0 +1,89 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/expr"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// PlanFunc describes what a command would do with the params, without doing it, for a dry run.
+type PlanFunc func(ctx context.Context, params interface{}) (*Action, error)
+
+// Action is what a step would do, as described by the Plan of its command.
+type Action struct {
+	Description string      `json:"description"`
+	Command     string      `json:"command,omitempty"` // the command line that would be run
+	URL         string      `json:"url,omitempty"`     // the url that would be requested
+	Files       []string    `json:"files,omitempty"`   // the files and dirs that would be written, moved or deleted
+	Workdir     string      `json:"workdir,omitempty"` // the dir the commands would run in, when the flow or step sets one
+	Content     string      `json:"content,omitempty"` // the content of the file that would be written
+	Params      interface{} `json:"params,omitempty"`  // the params with the vars and args resolved
+	If          string      `json:"if,omitempty"`      // the step only runs if this is true, when it can't be known before the run
+	Steps       []*Response `json:"steps,omitempty"`   // the plan of the steps of a sub-flow
+}
+
+// plan resolves the action of a step in a dry run. The params are checked against the params of the command
+// first, so a dry run fails on the same wrong params that validate reports.
+func (bt *BuildTool) plan(ctx context.Context, cmd string, params interface{}) (*Action, error) {
+	if _, ok := bt.CommandMap[cmd]; !ok {
+		return nil, fmt.Errorf("unknown command: %s", cmd)
+	}
+	if err := checkParamValues(cmd, params); err != nil {
+		return nil, err
+	}
+	planFunc := bt.Commands[cmd].Plan
+	if planFunc == nil {
+		return &Action{Description: fmt.Sprintf("run %s", cmd), Params: params}, nil
+	}
+	action, err := planFunc(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if action.Params == nil {
+		action.Params = params
+	}
+	return action, nil
+}
+
+// checkParamValues checks resolved params against the params of the command, see validator.checkParams.
+func checkParamValues(cmd string, params interface{}) error {
+	schema := commandParams[cmd]
+	if schema == nil {
+		return nil
+	}
+	var node yaml.Node
+	if err := node.Encode(params); err != nil {
+		return err
+	}
+	v := &validator{}
+	v.checkParams(&node, &node, cmd, schema)
+	var errs []string
+	for _, issue := range v.issues {
+		if issue.Severity == SeverityError {
+			errs = append(errs, issue.Message)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// paramStrings returns string or list params as a list of strings, like the dirs and systemctl commands take them.
+func paramStrings(params interface{}) []string {
+	switch p := params.(type) {
+	case string:
+		return strings.Fields(p)
+	case []string:
+		return p
+	case []interface{}:
+		list := make([]string, 0, len(p))
+		for _, v := range p {
+			list = append(list, expr.ToString(v))
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// commandContext is exec.CommandContext running the command in its own process group, so that when ctx is
+// cancelled the whole group is killed, including anything started by a `bash -c`. The command gets the env,
+// and runs in the workdir, of the flow and the step, see withExecEnv.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if e, ok := ctx.Value(execKey{}).(*execEnv); ok {
+		cmd.Dir = e.dir
+		if len(e.env) > 0 {
+			cmd.Env = append(os.Environ(), e.env...)
+		}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// don't wait forever on output pipes held open by a process that escaped the group
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+// execKey is the context key of the execEnv of the running step.
+type execKey struct{}
+
+// execEnv is the env and the workdir the flow and the step set for the commands they run.
+type execEnv struct {
+	env []string // as KEY=value, on top of the environment of the process
+	dir string   // "" is the working dir of the process
+}
+
+// withExecEnv returns a context whose commands also get the env, and run in dir. The env is added to the env
+// already in ctx, so a step adds to the env of its flow, and a relative dir is relative to the workdir of ctx.
+func withExecEnv(ctx context.Context, env map[string]string, dir string) context.Context {
+	if len(env) == 0 && dir == "" {
+		return ctx
+	}
+	e := &execEnv{dir: workdir(ctx)}
+	if parent, ok := ctx.Value(execKey{}).(*execEnv); ok {
+		e.env = append(e.env, parent.env...)
+	}
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// the last value of a key wins
+		e.env = append(e.env, key+"="+env[key])
+	}
+	if dir != "" {
+		e.dir = resolvePath(ctx, dir)
+	}
+	return context.WithValue(ctx, execKey{}, e)
+}
+
+// workdir returns the workdir of the running step, "" when none is set.
+func workdir(ctx context.Context) string {
+	if e, ok := ctx.Value(execKey{}).(*execEnv); ok {
+		return e.dir
+	}
+	return ""
+}
+
+// resolvePath returns a relative path joined to the workdir of the running step, so that paths in the
+// params don't depend on where bios was started.
+func resolvePath(ctx context.Context, path string) string {
+	dir := workdir(ctx)
+	if path == "" || dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// parseTimeout parses a step or flow timeout, an empty timeout is no timeout.
+func parseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %v", timeout, err)
+	}
+	return d, nil
+}
+
+// withTimeout is context.WithTimeout, or context.WithCancel when there is no timeout.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
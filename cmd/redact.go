@@ -0,0 +1,90 @@
+package commander
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// redactedValue replaces the values of the secret args wherever they would be shown.
+const redactedValue = "***"
+
+// redactor replaces the values of the secret args of a run in its output, responses and errors.
+type redactor struct {
+	values []string
+}
+
+// newRedactor creates the redactor of the secret args of the flow, with their resolved values.
+func newRedactor(buildYAML *BuildYAML, args map[string]string) *redactor {
+	r := &redactor{}
+	for _, arg := range buildYAML.Args {
+		if val := args[arg.Name]; arg.Secret && val != "" {
+			r.values = append(r.values, val)
+		}
+	}
+	// a secret that contains another is replaced first
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+	return r
+}
+
+func (r *redactor) empty() bool {
+	return r == nil || len(r.values) == 0
+}
+
+// string replaces the secrets in s.
+func (r *redactor) string(s string) string {
+	if r.empty() {
+		return s
+	}
+	for _, val := range r.values {
+		s = strings.ReplaceAll(s, val, redactedValue)
+	}
+	return s
+}
+
+// value replaces the secrets in the strings, and the keys, of a plain value made of maps and lists.
+func (r *redactor) value(v interface{}) interface{} {
+	if r.empty() {
+		return v
+	}
+	switch t := v.(type) {
+	case string:
+		return r.string(t)
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			out[i] = r.value(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for key, val := range t {
+			out[r.string(key)] = r.value(val)
+		}
+		return out
+	}
+	return v
+}
+
+// response replaces the secrets in the result of a step, including its plan, attempts and iterations.
+func (r *redactor) response(out *Response) {
+	if r.empty() {
+		return
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return
+	}
+	if b, err = json.Marshal(r.value(v)); err != nil {
+		return
+	}
+	var redacted Response
+	if err := json.Unmarshal(b, &redacted); err != nil {
+		return
+	}
+	*out = redacted
+}
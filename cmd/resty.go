@@ -1,12 +1,38 @@
 package commander
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/NubeIO/bios-cli/libs/expr"
 	"github.com/go-resty/resty/v2"
+	"strconv"
 	"strings"
 )
 
-func (bt *BuildTool) handleRestyHTTPRequest(params interface{}) (interface{}, error) {
+// httpResponse is the response of the http command.
+type httpResponse struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   interface{}       `json:"body,omitempty"` // parsed when it is JSON
+}
+
+func (bt *BuildTool) planRestyHTTPRequest(_ context.Context, params interface{}) (*Action, error) {
+	paramMap, ok := params.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid params for Resty HTTP request")
+	}
+	url := paramString(paramMap, "url")
+	method := paramString(paramMap, "method")
+	switch strings.ToUpper(method) {
+	case "GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS":
+	default:
+		return nil, fmt.Errorf("unsupported HTTP method: %s", method)
+	}
+	return &Action{Description: fmt.Sprintf("%s %s", strings.ToUpper(method), url), URL: url}, nil
+}
+
+func (bt *BuildTool) handleRestyHTTPRequest(ctx context.Context, params interface{}) (interface{}, error) {
 	// Convert params to a map[string]interface{}
 	paramMap, ok := params.(map[string]interface{})
 	if !ok {
@@ -17,36 +43,49 @@ func (bt *BuildTool) handleRestyHTTPRequest(params interface{}) (interface{}, er
 	client := resty.New()
 
 	// Extract the URL
-	url, _ := paramMap["url"].(string)
+	url := paramString(paramMap, "url")
 
 	// Prepare the request
-	req := client.R()
+	req := client.R().SetContext(ctx)
 
 	// Set headers, if any
 	if headers, ok := paramMap["header"].(map[string]interface{}); ok {
 		for key, value := range headers {
-			req.SetHeader(key, fmt.Sprintf("%v", value))
+			req.SetHeader(key, expr.ToString(value))
+		}
+	}
+
+	// Set query params, if any
+	if query, ok := paramMap["query"].(map[string]interface{}); ok {
+		for key, value := range query {
+			req.SetQueryParam(key, expr.ToString(value))
 		}
 	}
 
-	// Set body, if any
-	if body, ok := paramMap["body"].(map[string]interface{}); ok {
+	// Set body, if any, maps and lists are sent as JSON and anything else as it is
+	switch body := paramMap["body"].(type) {
+	case nil:
+	case map[string]interface{}, []interface{}:
+		req.SetHeader("Content-Type", "application/json")
 		req.SetBody(body)
+	default:
+		req.SetBody(expr.ToString(body))
 	}
 
-	// Set basic auth, if any
+	// Set basic or bearer auth, if any
 	if auth, ok := paramMap["auth"].(map[string]interface{}); ok {
 		if basic, ok := auth["basic"].(map[string]interface{}); ok {
-			username, _ := basic["username"].(string)
-			password, _ := basic["password"].(string)
-			req.SetBasicAuth(username, password)
+			req.SetBasicAuth(paramString(basic, "username"), paramString(basic, "password"))
+		}
+		if token := paramString(auth, "bearer"); token != "" {
+			req.SetAuthToken(token)
 		}
 	}
 
 	// Execute the request based on the method
 	var resp *resty.Response
 	var err error
-	method, _ := paramMap["method"].(string)
+	method := paramString(paramMap, "method")
 	switch strings.ToUpper(method) {
 	case "GET":
 		resp, err = req.Get(url)
@@ -70,8 +109,34 @@ func (bt *BuildTool) handleRestyHTTPRequest(params interface{}) (interface{}, er
 		return nil, fmt.Errorf("resty HTTP request failed: %v", err)
 	}
 
-	fmt.Printf("Response status code: %d\n", resp.StatusCode())
-	fmt.Printf("Response body: %s\n", resp.String())
+	fmt.Fprintf(stepStdout(ctx), "Response status code: %d\n", resp.StatusCode())
+	fmt.Fprintf(stepStdout(ctx), "Response body: %s\n", resp.String())
 
-	return nil, nil
+	out := &httpResponse{Status: resp.StatusCode(), Header: make(map[string]string), Body: resp.String()}
+	for key := range resp.Header() {
+		out.Header[key] = resp.Header().Get(key)
+	}
+	var body interface{}
+	if err := json.Unmarshal(resp.Body(), &body); err == nil {
+		out.Body = body
+	}
+	// a 4xx or 5xx fails the step, so that retry: can retry it, with the response for register:
+	if resp.IsError() && !allowedStatus(paramMap["allowStatus"], resp.StatusCode()) {
+		return out, fmt.Errorf("%s %s returned %s", strings.ToUpper(method), url, resp.Status())
+	}
+	return out, nil
+}
+
+// allowedStatus reports whether the status code is one of the allowStatus param, a code or a list of them.
+func allowedStatus(allow interface{}, status int) bool {
+	codes, ok := allow.([]interface{})
+	if !ok {
+		codes = []interface{}{allow}
+	}
+	for _, code := range codes {
+		if code != nil && expr.ToString(code) == strconv.Itoa(status) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,111 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Retry is the retry policy of a step.
+type Retry struct {
+	Attempts int      `yaml:"attempts"` // total number of attempts, including the first one
+	Delay    string   `yaml:"delay"`    // wait before the second attempt, like 2s
+	Backoff  float64  `yaml:"backoff"`  // the delay is multiplied by this after each attempt, 1 by default
+	MaxDelay string   `yaml:"maxDelay"` // optional cap on the delay
+	On       []string `yaml:"on"`       // optional, only retry when the error contains one of these
+}
+
+// Attempt is the result of a single attempt of a step with a retry policy.
+type Attempt struct {
+	Attempt   int       `json:"attempt"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	Duration  string    `json:"duration"`
+}
+
+// delays parses the delays of the policy.
+func (r *Retry) delays() (delay, maxDelay time.Duration, err error) {
+	if r.Delay != "" {
+		delay, err = time.ParseDuration(r.Delay)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid retry delay %q: %v", r.Delay, err)
+		}
+	}
+	if r.MaxDelay != "" {
+		maxDelay, err = time.ParseDuration(r.MaxDelay)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid retry maxDelay %q: %v", r.MaxDelay, err)
+		}
+	}
+	return delay, maxDelay, nil
+}
+
+// shouldRetry reports whether the error matches the retry-on conditions of the policy.
+func (r *Retry) shouldRetry(err error) bool {
+	if len(r.On) == 0 {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, on := range r.On {
+		if strings.Contains(msg, strings.ToLower(on)) {
+			return true
+		}
+	}
+	return false
+}
+
+// executeWithRetry runs the handler of the step until it succeeds or the retry policy gives up,
+// it returns every attempt made when the step has a retry policy. Each attempt gets its own timeout.
+func (bt *BuildTool) executeWithRetry(ctx context.Context, handler CommandHandler, step BuildStep, timeout time.Duration) (interface{}, []Attempt, error) {
+	if step.Retry == nil || step.Retry.Attempts <= 1 {
+		ret, err := runHandler(ctx, handler, step.Params, timeout)
+		return ret, nil, err
+	}
+	delay, maxDelay, err := step.Retry.delays()
+	if err != nil {
+		return nil, nil, err
+	}
+	backoff := step.Retry.Backoff
+	if backoff <= 0 {
+		backoff = 1
+	}
+
+	var attempts []Attempt
+	for i := 1; ; i++ {
+		startedAt := time.Now()
+		ret, err := runHandler(ctx, handler, step.Params, timeout)
+		attempt := Attempt{Attempt: i, StartedAt: startedAt, Duration: time.Since(startedAt).String()}
+		if err == nil {
+			attempts = append(attempts, attempt)
+			return ret, attempts, nil
+		}
+		attempt.Error = err.Error()
+		attempts = append(attempts, attempt)
+		if i >= step.Retry.Attempts || !step.Retry.shouldRetry(err) || ctx.Err() != nil {
+			return ret, attempts, err
+		}
+		fmt.Fprintf(stepStderr(ctx), "attempt %d of %d failed: %v, retrying in %s\n", i, step.Retry.Attempts, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ret, attempts, fmt.Errorf("%v, stopped retrying: %v", err, ctx.Err())
+		}
+		delay = time.Duration(float64(delay) * backoff)
+		if maxDelay > 0 && delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// runHandler runs the handler with the timeout.
+func runHandler(ctx context.Context, handler CommandHandler, params interface{}, timeout time.Duration) (interface{}, error) {
+	stepCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	ret, err := handler(stepCtx, params)
+	// only blame the step timeout when it was the step, and not the flow, that ran out of time
+	if err != nil && ctx.Err() == nil && stepCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s: %v", timeout, err)
+	}
+	return ret, err
+}
@@ -0,0 +1,89 @@
+package commander
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		flow string
+		want []string
+	}{
+		{
+			name: "completed steps in reverse before finally",
+			flow: `
+steps:
+  - name: first
+    cmd: ok
+    rollback:
+      - {name: undo first, cmd: ok}
+  - name: second
+    cmd: ok
+    rollback:
+      - {name: undo second, cmd: ok}
+      - {name: undo second again, cmd: ok}
+  - name: third
+    cmd: fail
+    rollback:
+      - {name: undo third, cmd: ok}
+  - name: fourth
+    cmd: ok
+    rollback:
+      - {name: undo fourth, cmd: ok}
+finally:
+  - {name: cleanup, cmd: ok}`,
+			want: []string{"first=succeeded", "second=succeeded", "third=failed", "fourth=skipped",
+				"undo second=succeeded", "undo second again=succeeded", "undo first=succeeded", "cleanup=succeeded"},
+		},
+		{
+			name: "not on success",
+			flow: `
+steps:
+  - name: first
+    cmd: ok
+    rollback:
+      - {name: undo first, cmd: ok}
+finally:
+  - {name: cleanup, cmd: ok}`,
+			want: []string{"first=succeeded", "cleanup=succeeded"},
+		},
+		{
+			name: "not when the failed step continues on error",
+			flow: `
+steps:
+  - name: first
+    cmd: ok
+    rollback:
+      - {name: undo first, cmd: ok}
+  - {name: second, cmd: fail, continueOnError: true}`,
+			want: []string{"first=succeeded", "second=failed"},
+		},
+		{
+			name: "finally sees the failed flow",
+			flow: `
+steps:
+  - name: first
+    cmd: fail
+finally:
+  - {name: on success, cmd: ok, if: "!flow.failed"}
+  - {name: on failure, cmd: ok, if: flow.failed}`,
+			want: []string{"first=failed", "on success=skipped", "on failure=succeeded"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := testTool().RunFlow(context.Background(), testFlow(t, tt.flow), nil)
+			if got := statuses(resp); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			for _, r := range resp {
+				if strings.HasPrefix(r.Name, "undo") && r.Phase != PhaseRollback {
+					t.Errorf("step %s ran in phase %q", r.Name, r.Phase)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,582 @@
+package commander
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/events"
+	"github.com/NubeIO/bios-cli/libs/expr"
+	"github.com/NubeIO/bios-cli/libs/runs"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Step statuses reported by RunFlow.
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+	StatusPlanned   = "planned" // the step would run, see BuildTool.DryRun
+)
+
+// Flow onError policies.
+const (
+	OnErrorStop     = "stop"     // skip the rest of the steps once a step fails, the default
+	OnErrorContinue = "continue" // keep running the rest of the steps
+)
+
+// Phases of the steps that don't belong to the steps: section of a flow.
+const (
+	PhaseRollback = "rollback" // the rollback: steps of a completed step, run when the flow fails
+	PhaseFinally  = "finally"  // the steps of the finally: section
+)
+
+// Response is the result of a single step of a flow.
+type Response struct {
+	File       string      `json:"file,omitempty"`
+	Name       string      `json:"name,omitempty"`
+	Cmd        string      `json:"cmd,omitempty"`
+	StepCount  int         `json:"stepCount,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	Phase      string      `json:"phase,omitempty"`
+	Response   interface{} `json:"response,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Plan       *Action     `json:"plan,omitempty"` // what the step would do, in a dry run
+	Attempts   []Attempt   `json:"attempts,omitempty"`
+	Iterations []*Response `json:"iterations,omitempty"` // the result of each item of a foreach: step
+	// ContinueOnError is set on a failed step that doesn't fail the flow
+	ContinueOnError bool       `json:"continueOnError,omitempty"`
+	StartedAt       *time.Time `json:"startedAt,omitempty"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty"`
+}
+
+// StepHook is called by RunFlow when a step starts and again when it finishes.
+type StepHook func(resp *Response)
+
+// RunFile loads a flow file and runs its steps with the given args.
+func (bt *BuildTool) RunFile(ctx context.Context, filename string, args map[string]string) []*Response {
+	buildYAML, err := bt.LoadBuildYAML(filename)
+	if err != nil {
+		out := &Response{File: filename, Status: StatusFailed, Error: err.Error()}
+		record := &runs.Run{ID: runs.NewID(), File: filename, Args: redactAllArgs(args), StartedAt: time.Now()}
+		bt.recordRun(record, []*Response{out})
+		return []*Response{out}
+	}
+	return bt.RunFlow(ctx, buildYAML, args)
+}
+
+// flowRun is the state of a single RunFlow.
+type flowRun struct {
+	bt     *BuildTool
+	flow   *BuildYAML
+	args   map[string]string
+	record *runs.Run
+	log    *runLog
+	redact *redactor              // replaces the values of the secret args in everything the run outputs
+	all    []*Response            // the result of every step
+	steps  map[string]interface{} // the result of every step by name, for expressions
+	failed *Response              // the step that failed the flow
+	mu     sync.Mutex             // steps run in parallel, guards all, steps, failed and the vars
+}
+
+// RunFlow runs the steps of a loaded flow, resolving the params of each step from the flow vars and args,
+// and returns the result of every step. The running step is stopped when ctx is cancelled or the flow timeout
+// is reached. Once a step fails the rest are skipped, unless the step has continueOnError or the flow has
+// onError: continue. If the flow failed, the rollback: steps of the completed steps are run in reverse order.
+// Then the finally: steps are run no matter what.
+func (bt *BuildTool) RunFlow(ctx context.Context, buildYAML *BuildYAML, args map[string]string) []*Response {
+	return bt.run(ctx, buildYAML, args).all
+}
+
+// run runs the flow for RunFlow, and for flow steps that also want its outputs.
+func (bt *BuildTool) run(ctx context.Context, buildYAML *BuildYAML, args map[string]string) *flowRun {
+	ctx = withFlow(ctx, buildYAML.File)
+	// vars are set by steps as the flow runs, so work on a copy of them
+	bt.buildYAML = *buildYAML
+	bt.buildYAML.Vars = append([]Variable(nil), buildYAML.Vars...)
+	r := &flowRun{
+		bt:   bt,
+		flow: &bt.buildYAML,
+		args: args,
+		record: &runs.Run{
+			ID:        runs.NewID(),
+			File:      buildYAML.File,
+			Name:      buildYAML.Name,
+			Args:      buildYAML.RedactArgs(args),
+			StartedAt: time.Now(),
+		},
+		steps: make(map[string]interface{}),
+	}
+	var err error
+	if !bt.DryRun {
+		r.log, err = bt.openRunLog(r.record.ID)
+		if err != nil {
+			log.Printf("run %s will not be logged: %v", r.record.ID, err)
+		}
+		if r.log != nil {
+			r.record.Log = r.log.path
+		}
+		bt.recordStart(r.record)
+		defer func() {
+			r.log.close()
+			bt.recordRun(r.record, r.all)
+		}()
+	}
+
+	timeout, err := parseTimeout(buildYAML.Timeout)
+	if err != nil {
+		return r.flowError(err)
+	}
+	if err := checkOnError(buildYAML.OnError); err != nil {
+		return r.flowError(err)
+	}
+	if r.args, err = buildYAML.resolveArgs(args, bt.Secrets, bt.ArgFiles); err != nil {
+		return r.flowError(err)
+	}
+	r.redact = newRedactor(buildYAML, r.args)
+	r.renderVars()
+	flowDir, _ := filepath.Abs(filepath.Dir(buildYAML.File))
+	if ctx, err = r.withEnv(ctx, buildYAML.Env, buildYAML.Workdir, flowDir, r.scope()); err != nil {
+		return r.flowError(err)
+	}
+	deps, explicit, err := buildYAML.stepGraph()
+	if err != nil {
+		return r.flowError(err)
+	}
+	stepsCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	// the steps keep their index in the response, whatever order they run in
+	r.all = make([]*Response, len(r.flow.Steps))
+	completed := r.runGraph(stepsCtx, deps, explicit)
+
+	// rollback and finally steps run even when the flow was cancelled or timed out, only their own timeout applies
+	cleanupCtx := context.WithoutCancel(ctx)
+	if r.failure() != nil && !bt.DryRun {
+		for i := len(completed) - 1; i >= 0; i-- {
+			for _, step := range completed[i].Rollback {
+				out := r.runStep(cleanupCtx, len(r.all), step, PhaseRollback)
+				r.checkFailed(step, out)
+			}
+		}
+	}
+	for _, step := range r.flow.finallySteps() {
+		out := r.runStep(cleanupCtx, len(r.all), step, PhaseFinally)
+		r.checkFailed(step, out)
+	}
+	return r
+}
+
+// flowError fails the run with an error about the flow itself, before any step runs.
+func (r *flowRun) flowError(err error) *flowRun {
+	out := &Response{File: r.flow.File, Status: StatusFailed, Error: fmt.Sprintf("flow: %v", err)}
+	r.all = append(r.all, out)
+	return r
+}
+
+// checkFailed remembers the first failed step that fails the flow.
+func (r *flowRun) checkFailed(step BuildStep, out *Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if out.Status != StatusFailed {
+		return
+	}
+	if step.ContinueOnError {
+		out.ContinueOnError = true
+		return
+	}
+	if r.failed == nil {
+		r.failed = out
+	}
+}
+
+// failure returns the step that failed the flow so far.
+func (r *flowRun) failure() *Response {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failed
+}
+
+// add puts the result of a step at its index, or at the end for rollback and finally steps.
+func (r *flowRun) add(out *Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if out.Phase == "" && out.StepCount < len(r.all) {
+		r.all[out.StepCount] = out
+		return
+	}
+	r.all = append(r.all, out)
+}
+
+// setStep makes the result of the step available to the expressions of later steps.
+func (r *flowRun) setStep(name string, out *Response) {
+	scope := stepScope(out)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.steps[name] = scope
+}
+
+// skip reports a step as skipped without running it.
+func (r *flowRun) skip(i int, step BuildStep, phase, reason string) *Response {
+	now := time.Now()
+	out := &Response{
+		Name:       step.Name,
+		Cmd:        step.Cmd,
+		StepCount:  i,
+		Phase:      phase,
+		Status:     StatusSkipped,
+		Error:      reason,
+		StartedAt:  &now,
+		FinishedAt: &now,
+	}
+	r.add(out)
+	r.bt.stepHook(r.record.ID, r.log, out)
+	r.setStep(step.Name, out)
+	return out
+}
+
+// Failure returns the step that failed the flow, or nil when the flow succeeded.
+func Failure(resp []*Response) *Response {
+	for _, out := range resp {
+		if out.Status == StatusFailed && !out.ContinueOnError {
+			return out
+		}
+	}
+	return nil
+}
+
+// runStep runs a single step, skipping it when its if: expression is false.
+func (r *flowRun) runStep(ctx context.Context, i int, step BuildStep, phase string) *Response {
+	startedAt := time.Now()
+	out := &Response{
+		Name:      step.Name,
+		Cmd:       step.Cmd,
+		StepCount: i,
+		Phase:     phase,
+		Status:    StatusRunning,
+		StartedAt: &startedAt,
+	}
+	r.add(out)
+	defer func() {
+		finishedAt := time.Now()
+		out.FinishedAt = &finishedAt
+		r.redact.response(out)
+		r.bt.stepHook(r.record.ID, r.log, out)
+		r.setStep(step.Name, out)
+	}()
+
+	// in a dry run, conditions on the results of the steps can't be known
+	unknownIf := r.bt.DryRun && dependsOnResults(step.If)
+	if step.If != "" && !unknownIf {
+		ok, err := expr.EvalBool(step.If, r.scope(), expr.Builtins)
+		if err != nil {
+			out.Status = StatusFailed
+			out.Error = fmt.Sprintf("invalid if expression %q: %v", step.If, err)
+			return out
+		}
+		if !ok {
+			out.Status = StatusSkipped
+			return out
+		}
+	}
+	r.bt.stepHook(r.record.ID, r.log, out)
+
+	if ctx.Err() != nil {
+		out.Status = StatusFailed
+		out.Error = fmt.Sprintf("flow stopped before the step ran: %v", ctx.Err())
+		return out
+	}
+	if step.Foreach != nil {
+		r.executeForeach(ctx, out, step)
+	} else {
+		r.execute(ctx, out, step, r.scope())
+	}
+	if out.Status == StatusFailed {
+		if out.Response != nil {
+			// so a later step can look at what failed, the error of the step is what is reported
+			r.register(step, out.Response)
+		}
+		return out
+	}
+	if r.bt.DryRun {
+		if unknownIf {
+			out.Plan.If = step.If
+		}
+		out.Status = StatusPlanned
+		return out
+	}
+	if err := r.register(step, out.Response); err != nil {
+		out.Status = StatusFailed
+		out.Error = err.Error()
+		return out
+	}
+	out.Status = StatusSucceeded
+	return out
+}
+
+// dependsOnResults reports whether the expression uses the results of the steps, or the status of the flow.
+func dependsOnResults(src string) bool {
+	node, err := expr.Parse(src)
+	if err != nil {
+		return false
+	}
+	for _, name := range expr.Names(node) {
+		if name == "steps" || name == "flow" {
+			return true
+		}
+	}
+	return false
+}
+
+// succeeded reports whether the step succeeded, or would run in a dry run.
+func succeeded(out *Response) bool {
+	return out.Status == StatusSucceeded || out.Status == StatusPlanned
+}
+
+// execute resolves the params of the step against the scope and runs its handler, setting the response,
+// or the error, on out.
+func (r *flowRun) execute(ctx context.Context, out *Response, step BuildStep, scope expr.Scope) {
+	ctx, err := r.withEnv(ctx, step.Env, step.Workdir, "", scope)
+	if err != nil {
+		out.Status = StatusFailed
+		out.Error = err.Error()
+		return
+	}
+	params, err := resolveParams(r.bt.template(), step.Params, scope)
+	if err != nil {
+		out.Status = StatusFailed
+		out.Error = fmt.Sprintf("params: %v", err)
+		return
+	}
+	if r.bt.DryRun {
+		plan, err := r.bt.plan(ctx, step.Cmd, params)
+		if err != nil {
+			out.Status = StatusFailed
+			out.Error = err.Error()
+			return
+		}
+		if plan.Workdir == "" {
+			plan.Workdir = workdir(ctx)
+		}
+		out.Plan = plan
+		return
+	}
+	output := r.bt.newStepOutput(ctx, r.record.ID, out.StepCount, step.Name, r.log, r.redact)
+	ret, attempts, err := r.bt.executeStep(output.withOutput(ctx), BuildStep{Name: step.Name, Cmd: step.Cmd, Params: params, Retry: step.Retry, Timeout: step.Timeout})
+	output.flush()
+	out.Attempts = attempts
+	// a failed step can have a response too, like the status and body of an http error
+	out.Response = ret
+	if err != nil {
+		out.Status = StatusFailed
+		out.Error = err.Error()
+		if ctx.Err() == context.DeadlineExceeded {
+			out.Error = fmt.Sprintf("flow timed out after %s: %v", r.flow.Timeout, err)
+		}
+		return
+	}
+}
+
+// register sets the vars of the step register: from the handler response.
+func (r *flowRun) register(step BuildStep, ret interface{}) error {
+	for name, path := range step.Register {
+		value, err := extract(ret, path)
+		if err != nil {
+			return fmt.Errorf("failed to register %s: %v", name, err)
+		}
+		r.mu.Lock()
+		r.bt.UpdateVar(name, value)
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// extract gets the value at a JSON path like $.assets[0].name from a handler response,
+// an empty path or $ is the whole response.
+func extract(v interface{}, path string) (interface{}, error) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	if path == "" {
+		return toGeneric(v), nil
+	}
+	if !strings.HasPrefix(path, ".") && !strings.HasPrefix(path, "[") {
+		path = "." + path
+	}
+	return expr.Eval("value"+path, expr.Scope{"value": toGeneric(v)}, nil)
+}
+
+// scope is what the expressions of the flow can reference at this point of the run.
+func (r *flowRun) scope() expr.Scope {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	steps := make(map[string]interface{}, len(r.steps))
+	for name, step := range r.steps {
+		steps[name] = step
+	}
+	scope := flowScope(r.flow, r.args, steps)
+	flow := map[string]interface{}{"status": StatusRunning, "failed": false, "error": ""}
+	if r.failed != nil {
+		flow = map[string]interface{}{"status": StatusFailed, "failed": true, "error": r.failed.Error}
+	}
+	scope["flow"] = flow
+	return scope
+}
+
+// flowScope is what the expressions of a flow can reference: the vars and args by name, or as vars.name
+// and args.name, the result of the steps run so far as steps.<step name>.status|response|error, and the
+// environment variables as env.NAME.
+func flowScope(buildYAML *BuildYAML, args map[string]string, steps map[string]interface{}) expr.Scope {
+	scope := expr.Scope{}
+	vars := make(map[string]interface{})
+	for _, v := range buildYAML.Vars {
+		vars[v.Name] = v.Value
+		scope[v.Name] = v.Value
+	}
+	argsMap := make(map[string]interface{})
+	for _, name := range buildYAML.argNames() {
+		if _, ok := scope[name]; !ok {
+			argsMap[name] = ""
+			scope[name] = ""
+		}
+	}
+	for key, val := range args {
+		argsMap[key] = val
+		scope[key] = val
+	}
+	scope["vars"] = vars
+	scope["args"] = argsMap
+	scope["steps"] = steps
+	scope["env"] = expr.Environ()
+	return scope
+}
+
+// withEnv resolves the ${...} references in the env and the workdir of the flow or a step, and returns a
+// context whose commands get them, see withExecEnv. A relative workdir is relative to base when it is set.
+func (r *flowRun) withEnv(ctx context.Context, env map[string]string, dir, base string, scope expr.Scope) (context.Context, error) {
+	if len(env) == 0 && dir == "" {
+		return ctx, nil
+	}
+	tmpl := r.bt.template()
+	rendered := make(map[string]string, len(env))
+	for key, val := range env {
+		v, err := tmpl.Render(val, scope)
+		if err != nil {
+			return nil, fmt.Errorf("env %s: %v", key, err)
+		}
+		rendered[key] = expr.ToString(v)
+	}
+	v, err := tmpl.Render(dir, scope)
+	if err != nil {
+		return nil, fmt.Errorf("workdir: %v", err)
+	}
+	dir = expr.ToString(v)
+	if dir != "" && base != "" && !filepath.IsAbs(dir) {
+		dir = filepath.Join(base, dir)
+	}
+	return withExecEnv(ctx, rendered, dir), nil
+}
+
+// template is the template engine of the params, it leaves what can't be resolved yet as it is in a dry run.
+func (bt *BuildTool) template() *expr.Template {
+	return &expr.Template{Funcs: expr.Builtins, Lenient: bt.DryRun}
+}
+
+// renderVars resolves the ${...} references in the values of the vars, in order, so a var can be made of
+// the args and the vars before it. References to what isn't known yet, like registered vars, are left as they are.
+func (r *flowRun) renderVars() {
+	tmpl := &expr.Template{Funcs: expr.Builtins, Lenient: true}
+	for i, v := range r.flow.Vars {
+		if value, err := resolveParams(tmpl, v.Value, flowScope(r.flow, r.args, nil)); err == nil {
+			r.flow.Vars[i].Value = value
+		}
+	}
+}
+
+// stepScope is the result of a step as seen by the expressions of later steps. The fields of a map
+// response can also be used directly, so steps.download.path is the same as steps.download.response.path.
+func stepScope(out *Response) map[string]interface{} {
+	response := toGeneric(out.Response)
+	scope := make(map[string]interface{})
+	if m, ok := response.(map[string]interface{}); ok {
+		for key, val := range m {
+			scope[key] = val
+		}
+	}
+	scope["status"] = out.Status
+	scope["response"] = response
+	scope["error"] = out.Error
+	return scope
+}
+
+// toGeneric converts a handler response to plain maps and lists using its JSON field names.
+func toGeneric(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// recordStart saves the run as running to bt.Runs, so that `runs list` shows it while it runs and a run that never
+// ends, because bios was killed, is still in the history. recordRun saves it again when it ends.
+func (bt *BuildTool) recordStart(run *runs.Run) {
+	if bt.Runs == nil {
+		return
+	}
+	started := *run
+	started.Status = StatusRunning
+	if err := bt.Runs.Add(&started); err != nil {
+		log.Printf("failed to record run %s: %v", run.ID, err)
+	}
+}
+
+// recordRun saves the run with the result of every step to bt.Runs.
+func (bt *BuildTool) recordRun(run *runs.Run, steps []*Response) {
+	if bt.Runs == nil {
+		return
+	}
+	run.FinishedAt = time.Now()
+	run.Duration = run.FinishedAt.Sub(run.StartedAt).String()
+	run.Steps = steps
+	run.Status = StatusSucceeded
+	if Failure(steps) != nil {
+		run.Status = StatusFailed
+	}
+	for _, step := range steps {
+		if step.Status == StatusFailed {
+			if step.File != "" {
+				run.Errors = append(run.Errors, step.Error)
+			} else {
+				run.Errors = append(run.Errors, fmt.Sprintf("step %d (%s): %s", step.StepCount, step.Name, step.Error))
+			}
+		}
+	}
+	if err := bt.Runs.Add(run); err != nil {
+		log.Printf("failed to record run %s: %v", run.ID, err)
+	}
+}
+
+// stepHook publishes the status of the step and calls bt.OnStep.
+func (bt *BuildTool) stepHook(runID string, runLog *runLog, resp *Response) {
+	bt.publish(runLog, events.Event{
+		Type:   events.TypeStep,
+		RunID:  runID,
+		Index:  resp.StepCount,
+		Step:   resp.Name,
+		Status: resp.Status,
+	})
+	if bt.OnStep != nil {
+		bt.OnStep(resp)
+	}
+}
@@ -0,0 +1,286 @@
+package commander
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Params describes the params of a command, for Validate and the JSON Schema of flow files.
+type Params struct {
+	Kinds    []string         // the shapes the params can have: string, list and map, none when the command takes no params
+	Fields   map[string]Field // the keys of map params
+	Required []string         // the keys map params must have
+	Open     bool             // map params can have keys other than Fields, like the args of a flow step
+	Ops      []string         // the first word, or item, of string and list params must be one of these
+	Items    []string         // every item of list params must be one of these
+}
+
+// Field is a key of map params.
+type Field struct {
+	Kind string // string, list, map or any
+	Help string
+	Keys []string // the known keys of a map field, Validate warns about the others
+	// Check checks the value of the field, or the values of the keys of a map field, each item of a list.
+	// Validate reports the errors
+	Check func(key, value string) error
+}
+
+// commandParams are the params of the built-in commands.
+var commandParams = map[string]*Params{
+	"listCommands": {},
+	"time":         {},
+	"systemctl": {
+		Kinds: []string{"string", "list", "map"},
+		Fields: map[string]Field{
+			"action": {Kind: "string", Help: "status, is-active, daemon-reload, start, stop, restart, enable, disable, mask, unmask or reset-failed", Check: checkSystemctlAction},
+			"unit":   {Kind: "string", Help: "the unit, all but daemon-reload require it"},
+		},
+		Required: []string{"action"},
+	},
+	"bash": {Kinds: []string{"string"}},
+	"http": {
+		Kinds: []string{"map"},
+		Fields: map[string]Field{
+			"url":         {Kind: "string", Help: "the url to request"},
+			"method":      {Kind: "string", Help: "GET, POST, PUT, DELETE, PATCH, HEAD or OPTIONS"},
+			"header":      {Kind: "map", Help: "the request headers"},
+			"body":        {Kind: "any", Help: "the request body"},
+			"query":       {Kind: "map", Help: "the query params"},
+			"auth":        {Kind: "map", Help: "basic: {username, password}, or bearer: token"},
+			"allowStatus": {Kind: "any", Help: "the 4xx and 5xx status codes that don't fail the step, like [404]"},
+		},
+		Required: []string{"url", "method"},
+	},
+	"github-download": {
+		Kinds: []string{"map"},
+		Fields: map[string]Field{
+			"owner":    {Kind: "string", Help: "the owner of the repo"},
+			"repo":     {Kind: "string", Help: "the repo to download the release of"},
+			"tag":      {Kind: "string", Help: "the tag of the release"},
+			"arch":     {Kind: "string", Help: "the release asset with this arch in its name is downloaded"},
+			"token":    {Kind: "string", Help: "a GitHub token"},
+			"location": {Kind: "string", Help: "the dir to download to, ./ by default"},
+			"options":  {Kind: "map", Help: "asset: a pattern like *armv7*.zip to pick the asset by, unzip: a dir to unzip it to"},
+		},
+		Required: []string{"owner", "repo", "tag"},
+	},
+	"dirs": {
+		Kinds: []string{"string", "list"},
+		Ops:   []string{"mkdir", "delete", "unzip", "mv", "rename", "walkup", "walkdown", "listfiles"},
+	},
+	"systemctl-file": {
+		Kinds: []string{"map"},
+		Fields: map[string]Field{
+			"name":        {Kind: "string", Help: "the name of the service", Check: checkFileName},
+			"description": {Kind: "string", Help: "the Description of [Unit]"},
+			"ExecStart":   {Kind: "string", Help: "the ExecStart of [Service]"},
+			"Restart":     {Kind: "string", Help: "the Restart of [Service]"},
+			"unit":        {Kind: "map", Help: "the directives of [Unit]", Keys: unitDirectives[SectionUnit]},
+			"service":     {Kind: "map", Help: "the directives of [Service], a list is a repeated directive", Keys: unitDirectives[SectionService]},
+			"timer":       {Kind: "map", Help: "the directives of [Timer], for a .timer name", Keys: unitDirectives[SectionTimer], Check: checkTimerDirective},
+			"install":     {Kind: "map", Help: "the directives of [Install], WantedBy=multi-user.target by default", Keys: unitDirectives[SectionInstall]},
+			"op":          {Kind: "string", Help: "create (default) or edit the existing file in the location"},
+			"add":         {Kind: "map", Help: "for edit, the directives to add by section param, like service: {Environment: [A=1]}"},
+			"remove":      {Kind: "map", Help: "for edit, the keys, or the directives, to remove by section param, like service: [Restart]"},
+			"tmp":         {Kind: "string", Help: "the dir the file is generated in"},
+			"location":    {Kind: "string", Help: "the dir the file is moved to"},
+		},
+		Required: []string{"name"},
+	},
+	"systemctl-dropin": {
+		Kinds: []string{"map"},
+		Fields: map[string]Field{
+			"op":       {Kind: "string", Help: "create (default), list, remove, or show the effective configuration of the unit"},
+			"name":     {Kind: "string", Help: "the unit, a service when it has no extension", Check: checkFileName},
+			"dropin":   {Kind: "string", Help: "the name of the drop-in file, override by default", Check: checkFileName},
+			"location": {Kind: "string", Help: "the dir of the unit, /etc/systemd/system by default"},
+			"unit":     {Kind: "map", Help: "the directives of [Unit]", Keys: unitDirectives[SectionUnit]},
+			"service":  {Kind: "map", Help: "the directives of [Service], a list is a repeated directive", Keys: unitDirectives[SectionService]},
+			"timer":    {Kind: "map", Help: "the directives of [Timer], for a timer", Keys: unitDirectives[SectionTimer], Check: checkTimerDirective},
+			"install":  {Kind: "map", Help: "the directives of [Install]", Keys: unitDirectives[SectionInstall]},
+		},
+		Required: []string{"name"},
+	},
+	"systemctl-timer": {
+		Kinds: []string{"map"},
+		Fields: map[string]Field{
+			"op":          {Kind: "string", Help: "create (default), or list the timers with their next and last trigger times"},
+			"name":        {Kind: "string", Help: "the name of the timer and of the service it starts, for list only this timer", Check: checkFileName},
+			"description": {Kind: "string", Help: "the Description of the units"},
+			"ExecStart":   {Kind: "string", Help: "the ExecStart of the service"},
+			"timer":       {Kind: "map", Help: "the directives of [Timer], like OnCalendar: daily, OnBootSec: 5min or Persistent: true", Keys: unitDirectives[SectionTimer], Check: checkTimerDirective},
+			"unit":        {Kind: "map", Help: "the directives of [Unit] of the service", Keys: unitDirectives[SectionUnit]},
+			"service":     {Kind: "map", Help: "the directives of [Service] of the service, Type=oneshot by default", Keys: unitDirectives[SectionService]},
+			"install":     {Kind: "map", Help: "the directives of [Install] of the timer, WantedBy=timers.target by default", Keys: unitDirectives[SectionInstall]},
+			"location":    {Kind: "string", Help: "the dir the units are written to, /etc/systemd/system by default"},
+		},
+	},
+	"system": {
+		Kinds: []string{"list"},
+		Items: []string{"ip", "uptime"},
+	},
+	"flow": {
+		Kinds: []string{"map"},
+		Fields: map[string]Field{
+			"file": {Kind: "string", Help: "the flow file to run, relative to this one, the other params are its args"},
+		},
+		Required: []string{"file"},
+		Open:     true,
+	},
+}
+
+// yamlKey returns the yaml key of a struct field, "" for a field that isn't in the yaml.
+func yamlKey(f reflect.StructField) string {
+	key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// yamlKeys returns the yaml keys of the fields of a struct type.
+func yamlKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		if key := yamlKey(t.Field(i)); key != "" {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+var (
+	stepType     = reflect.TypeOf(BuildStep{})
+	registerType = reflect.TypeOf(Register{})
+	argType      = reflect.TypeOf(Arg{})
+)
+
+// JSONSchema returns a JSON Schema of flow files, for editors to complete and check them.
+func (bt *BuildTool) JSONSchema() map[string]interface{} {
+	schema := structSchema(reflect.TypeOf(BuildYAML{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "bios flow"
+	schema["properties"].(map[string]interface{})["onError"] = map[string]interface{}{
+		"type": "string", "enum": []string{OnErrorStop, OnErrorContinue},
+	}
+
+	step := structSchema(stepType)
+	names := make([]string, 0, len(bt.CommandMap))
+	for name := range bt.CommandMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	step["properties"].(map[string]interface{})["cmd"] = map[string]interface{}{"type": "string", "enum": names}
+	step["required"] = []string{"cmd"}
+	var byCmd []interface{}
+	for _, name := range names {
+		params, ok := commandParams[name]
+		if !ok {
+			continue
+		}
+		then := map[string]interface{}{"properties": map[string]interface{}{"params": params.jsonSchema()}}
+		if len(params.Kinds) > 0 {
+			then["required"] = []string{"params"}
+		}
+		byCmd = append(byCmd, map[string]interface{}{
+			"if": map[string]interface{}{
+				"properties": map[string]interface{}{"cmd": map[string]interface{}{"const": name}},
+				"required":   []string{"cmd"},
+			},
+			"then": then,
+		})
+	}
+	step["allOf"] = byCmd
+	schema["definitions"] = map[string]interface{}{"step": step}
+	return schema
+}
+
+// typeSchema returns the JSON Schema of a type of a flow file, from its yaml keys.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case stepType:
+		// steps nest in rollback:, so the step is defined once by JSONSchema
+		return map[string]interface{}{"$ref": "#/definitions/step"}
+	case argType:
+		return map[string]interface{}{"oneOf": []interface{}{map[string]interface{}{"type": "string"}, structSchema(t)}}
+	case registerType:
+		return map[string]interface{}{"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+		}}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema returns the JSON Schema of a struct type, an object of its yaml keys.
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := yamlKey(t.Field(i)); key != "" {
+			properties[key] = typeSchema(t.Field(i).Type)
+		}
+	}
+	return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// jsonSchema returns the JSON Schema of the params.
+func (p *Params) jsonSchema() map[string]interface{} {
+	schema := make(map[string]interface{})
+	var types []string
+	for _, kind := range p.Kinds {
+		types = append(types, kindType(kind))
+	}
+	if len(types) > 0 {
+		schema["type"] = types
+	}
+	if len(p.Fields) > 0 {
+		properties := make(map[string]interface{})
+		for key, field := range p.Fields {
+			property := make(map[string]interface{})
+			if field.Kind != "any" {
+				property["type"] = kindType(field.Kind)
+			}
+			if field.Help != "" {
+				property["description"] = field.Help
+			}
+			properties[key] = property
+		}
+		schema["properties"] = properties
+		schema["additionalProperties"] = p.Open
+	}
+	if len(p.Required) > 0 {
+		schema["required"] = p.Required
+	}
+	if len(p.Items) > 0 {
+		schema["items"] = map[string]interface{}{"enum": p.Items}
+	}
+	return schema
+}
+
+// kindType is the JSON Schema type of a kind of param.
+func kindType(kind string) string {
+	switch kind {
+	case "list":
+		return "array"
+	case "map":
+		return "object"
+	}
+	return kind
+}
@@ -1,80 +1,495 @@
 package commander
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
+	"github.com/NubeIO/bios-cli/libs/execute/commands"
+	"github.com/NubeIO/bios-cli/libs/expr"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 )
 
-func (bt *BuildTool) handleSystemctl(params interface{}) (interface{}, error) {
-	return nil, bt.executeCommand("systemctl", params)
+// Actions of the systemctl step that aren't a commands.SystemdActions.
+const (
+	SystemctlStatus       = "status"        // the status of the unit
+	SystemctlIsActive     = "is-active"     // the active state of the unit, the step doesn't fail when it isn't active
+	SystemctlDaemonReload = "daemon-reload" // reload the unit files, it takes no unit
+)
+
+// systemctlActions are the actions of the map params of the systemctl step.
+var systemctlActions = append([]string{SystemctlStatus, SystemctlIsActive, SystemctlDaemonReload}, commands.SystemdActions...)
+
+// handleSystemctl runs systemctl through commands.Commands for map params:
+//
+//	action: restart
+//	unit: driver-bacnet
+//
+// and returns the commands.StatusResp of the unit, once the action is done. String and list params, like
+// "restart driver-bacnet", are passed to systemctl as they are.
+func (bt *BuildTool) handleSystemctl(ctx context.Context, params interface{}) (interface{}, error) {
+	paramMap, ok := params.(map[string]interface{})
+	if !ok {
+		return nil, bt.executeCommand(ctx, "systemctl", params)
+	}
+	action, unit, err := systemctlParams(paramMap)
+	if err != nil {
+		return nil, err
+	}
+	systemd := bt.systemd.WithContext(ctx)
+	switch action {
+	case SystemctlDaemonReload:
+		if err := systemd.SystemdDaemonReload(); err != nil {
+			return nil, fmt.Errorf("failed to reload the unit files: %v", err)
+		}
+		fmt.Fprintln(stepStdout(ctx), "Unit files reloaded")
+		return nil, nil
+	case SystemctlIsActive:
+		return systemd.SystemdIsActive(unit)
+	case SystemctlStatus:
+	default:
+		if err := systemd.SystemdCommand(unit, action); err != nil {
+			return nil, fmt.Errorf("failed to %s %s: %v", action, unit, err)
+		}
+		fmt.Fprintf(stepStdout(ctx), "systemctl %s %s done\n", action, unit)
+	}
+	status, err := systemd.SystemdStatus(unit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the status of %s: %v", unit, err)
+	}
+	return status, nil
+}
+
+// systemctlParams returns the action and the unit of map params of the systemctl step.
+func systemctlParams(params map[string]interface{}) (string, string, error) {
+	action := paramString(params, "action")
+	unit := trimNewline(paramString(params, "unit"))
+	if err := checkSystemctlAction("action", action); err != nil {
+		return "", "", err
+	}
+	if unit == "" && action != SystemctlDaemonReload {
+		return "", "", fmt.Errorf("systemctl %s requires a unit", action)
+	}
+	return action, unit, nil
 }
 
-func (bt *BuildTool) handleSystemctlFile(params interface{}) (interface{}, error) {
+// checkSystemctlAction checks the action of map params of the systemctl step, for Validate.
+func checkSystemctlAction(_, action string) error {
+	if !contains(systemctlActions, action) {
+		return fmt.Errorf("unknown systemctl action %q, try: %s", action, strings.Join(systemctlActions, ", "))
+	}
+	return nil
+}
+
+func (bt *BuildTool) planSystemctl(_ context.Context, params interface{}) (*Action, error) {
+	if paramMap, ok := params.(map[string]interface{}); ok {
+		action, unit, err := systemctlParams(paramMap)
+		if err != nil {
+			return nil, err
+		}
+		command := strings.TrimSpace("systemctl " + action + " " + unit)
+		return &Action{Description: command, Command: command}, nil
+	}
+	args := paramStrings(params)
+	if len(args) < 1 {
+		return nil, fmt.Errorf("systemctl command requires at least one argument")
+	}
+	return &Action{Description: fmt.Sprintf("systemctl %s", args[0]), Command: "systemctl " + strings.Join(args, " ")}, nil
+}
+
+// Operations of systemctl-file.
+const (
+	UnitFileCreate = "create" // generate the unit file from the params, the default
+	UnitFileEdit   = "edit"   // set, add or remove directives of the existing unit file, keeping the rest of it
+)
+
+func (bt *BuildTool) planSystemctlFile(ctx context.Context, params interface{}) (*Action, error) {
 	paramMap, ok := params.(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("invalid params for systemctl-file")
 	}
+	location := dirParam(ctx, paramMap, "location")
+	switch op := unitFileOp(paramMap); op {
+	case UnitFileCreate:
+		service, _, err := serviceFromParams(paramMap)
+		if err != nil {
+			return nil, err
+		}
+		content, err := service.Render()
+		if err != nil {
+			return nil, err
+		}
+		tmpPath := dirParam(ctx, paramMap, "tmp")
+		file := service.FileName()
+		return &Action{
+			Description: fmt.Sprintf("generate the service file %s and move it to %s", file, location),
+			Files:       []string{filepath.Join(tmpPath, file), filepath.Join(location, file)},
+			Content:     content,
+		}, nil
+	case UnitFileEdit:
+		file, u, changed, _, err := editUnitFile(paramMap, location)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			return &Action{Description: fmt.Sprintf("leave %s as it is, it is up to date", file)}, nil
+		}
+		return &Action{Description: fmt.Sprintf("edit %s", file), Files: []string{file}, Content: u.String()}, nil
+	default:
+		return nil, fmt.Errorf("unknown systemctl-file operation %q, try: %s or %s", op, UnitFileCreate, UnitFileEdit)
+	}
+}
 
-	name, _ := paramMap["name"].(string)
-	description, _ := paramMap["description"].(string)
-	execStart, _ := paramMap["ExecStart"].(string)
-	restart, _ := paramMap["Restart"].(string)
-	tmpPath, _ := paramMap["tmp"].(string)
-	location, _ := paramMap["location"].(string)
-	name = trimNewline(name)
-	// Create a new SystemctlService instance
-	service := NewSystemctlService(name, description, execStart, restart)
+// unitFileResult is the response of systemctl-file.
+type unitFileResult struct {
+	File string `json:"file"`
+	// Changed is set when the content of the file changed, so that systemd needs a daemon-reload
+	Changed  bool     `json:"changed"`
+	Warnings []string `json:"warnings,omitempty"` // the unknown directives, which systemd ignores
+}
+
+func (bt *BuildTool) handleSystemctlFile(ctx context.Context, params interface{}) (interface{}, error) {
+	paramMap, ok := params.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid params for systemctl-file")
+	}
+	location := dirParam(ctx, paramMap, "location")
+	switch op := unitFileOp(paramMap); op {
+	case UnitFileCreate:
+		return createUnitFile(ctx, paramMap, location)
+	case UnitFileEdit:
+		file, u, changed, warnings, err := editUnitFile(paramMap, location)
+		if err != nil {
+			return nil, err
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(stepStderr(ctx), "warning: %s\n", warning)
+		}
+		if changed {
+			if err := writeUnitFile(file, u.String()); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(stepStdout(ctx), "Systemctl service file %s edited\n", file)
+		}
+		return &unitFileResult{File: file, Changed: changed, Warnings: warnings}, nil
+	default:
+		return nil, fmt.Errorf("unknown systemctl-file operation %q, try: %s or %s", op, UnitFileCreate, UnitFileEdit)
+	}
+}
+
+func unitFileOp(params map[string]interface{}) string {
+	if op := paramString(params, "op"); op != "" {
+		return op
+	}
+	return UnitFileCreate
+}
+
+// createUnitFile generates the unit file of the params in the tmp dir, and moves it to the location.
+func createUnitFile(ctx context.Context, params map[string]interface{}, location string) (*unitFileResult, error) {
+	service, warnings, err := serviceFromParams(params)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(stepStderr(ctx), "warning: %s\n", warning)
+	}
+	file := filepath.Join(location, service.FileName())
+	previous, _ := os.ReadFile(file)
 
 	// Generate the service file
-	serviceFilePath, err := service.GenerateServiceFile(tmpPath)
+	serviceFilePath, err := service.GenerateServiceFile(dirParam(ctx, params, "tmp"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate systemctl service file: %v", err)
 	}
+	content, err := os.ReadFile(serviceFilePath)
+	if err != nil {
+		return nil, err
+	}
 
 	// Move the service file to the specified location
 	err = MoveServiceFile(serviceFilePath, location)
 	if err != nil {
 		return nil, fmt.Errorf("failed to move systemctl service file: %v", err)
 	}
-	out := fmt.Sprintf("Systemctl service file created and moved to: %s\n", filepath.Join(location, filepath.Base(serviceFilePath)))
-	return out, nil
+	fmt.Fprintf(stepStdout(ctx), "Systemctl service file created and moved to: %s\n", file)
+	return &unitFileResult{File: file, Changed: string(previous) != string(content), Warnings: warnings}, nil
+}
+
+// editUnitFile applies the changes of the params to the unit file of the service in the location, without
+// writing it. The directives of unit:, service: and install: are set, replacing the values they had, the ones
+// of add: are added unless the file already has them, and the ones of remove: are removed. remove: takes a
+// list of keys to remove, or a map of the values to remove:
+//
+//	op: edit
+//	name: driver
+//	location: /etc/systemd/system
+//	service:
+//	  RestartSec: 10
+//	add:
+//	  service:
+//	    Environment: {DEBUG: "1"}
+//	remove:
+//	  service: [Restrat]
+//	  unit: {After: mosquitto.service}
+func editUnitFile(params map[string]interface{}, location string) (string, *commands.UnitFile, bool, []string, error) {
+	name := trimNewline(paramString(params, "name"))
+	if name == "" {
+		return "", nil, false, nil, fmt.Errorf("systemctl-file requires a name")
+	}
+	if err := checkFileName("name", name); err != nil {
+		return "", nil, false, nil, err
+	}
+	file := filepath.Join(location, (&SystemctlService{Name: name}).FileName())
+	u, err := commands.ReadUnitFile(file)
+	if err != nil {
+		return "", nil, false, nil, fmt.Errorf("failed to read unit file: %v", err)
+	}
+	sets, err := unitChanges(params, false)
+	if err != nil {
+		return "", nil, false, nil, err
+	}
+	var adds, removes []unitChange
+	if raw, ok := params["add"]; ok && raw != nil {
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return "", nil, false, nil, fmt.Errorf("add should be a map of the sections to add directives to")
+		}
+		if adds, err = unitChanges(m, false); err != nil {
+			return "", nil, false, nil, fmt.Errorf("add: %v", err)
+		}
+	}
+	if raw, ok := params["remove"]; ok && raw != nil {
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return "", nil, false, nil, fmt.Errorf("remove should be a map of the sections to remove directives from")
+		}
+		if removes, err = unitChanges(m, true); err != nil {
+			return "", nil, false, nil, fmt.Errorf("remove: %v", err)
+		}
+	}
+
+	changed := false
+	var warnings []string
+	for _, c := range append(sets, adds...) {
+		if warning := directiveWarning(c.section, c.key); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	for _, c := range sets {
+		changed = u.Set(c.section, c.key, c.values...) || changed
+	}
+	for _, c := range adds {
+		for _, value := range c.values {
+			changed = u.Add(c.section, c.key, value) || changed
+		}
+	}
+	for _, c := range removes {
+		changed = u.Remove(c.section, c.key, c.values...) || changed
+	}
+	return file, u, changed, warnings, nil
+}
+
+// writeUnitFile replaces the content of the unit file, keeping its mode.
+func writeUnitFile(file, content string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.WriteFile(file, []byte(content), mode); err != nil {
+		return fmt.Errorf("failed to write unit file: %w", err)
+	}
+	return nil
+}
+
+// unitChange is a directive of the params of systemctl-file, with its values.
+type unitChange struct {
+	section string
+	key     string
+	values  []string
+}
+
+// unitChanges returns the directives of the unit:, service:, timer: and install: params, see serviceFromParams. With
+// keysOnly, a section can also be a list of keys without values, like the sections of remove:.
+func unitChanges(params map[string]interface{}, keysOnly bool) ([]unitChange, error) {
+	var changes []unitChange
+	for _, param := range []string{"unit", "service", "timer", "install"} {
+		raw, ok := params[param]
+		if !ok || raw == nil {
+			continue
+		}
+		section := unitSectionParams[param]
+		directives, ok := raw.(map[string]interface{})
+		if keys, isList := raw.([]interface{}); isList && keysOnly {
+			directives, ok = make(map[string]interface{}), true
+			for _, key := range keys {
+				directives[expr.ToString(key)] = nil
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s should be a map of directives", param)
+		}
+		for _, key := range directiveOrder(section, directives) {
+			values, err := directiveValues(key, directives[key])
+			if err != nil {
+				return nil, fmt.Errorf("%s in [%s]: %v", key, section, err)
+			}
+			for _, value := range values {
+				if err := checkDirective(section, key, value); err != nil {
+					return nil, err
+				}
+			}
+			changes = append(changes, unitChange{section: section, key: key, values: values})
+		}
+	}
+	return changes, nil
 }
 
-// SystemctlService represents a systemd service file.
+// Sections of a unit file.
+const (
+	SectionUnit    = "Unit"
+	SectionService = "Service"
+	SectionTimer   = "Timer" // of a .timer
+	SectionInstall = "Install"
+)
+
+// unitDirectives are the common directives of each section, in the order they are written in. Other directives
+// are written after them, and reported by Validate as they are most likely a typo.
+var unitDirectives = map[string][]string{
+	SectionUnit: {
+		"Description", "Documentation", "After", "Before", "Wants", "Requires", "Requisite", "BindsTo", "PartOf",
+		"Conflicts", "OnFailure", "DefaultDependencies", "StartLimitIntervalSec", "StartLimitBurst",
+		"ConditionPathExists", "ConditionPathIsDirectory", "ConditionFileIsExecutable", "AssertPathExists",
+	},
+	SectionService: {
+		"Type", "User", "Group", "SupplementaryGroups", "DynamicUser", "WorkingDirectory", "RootDirectory",
+		"Environment", "EnvironmentFile", "PIDFile", "ExecStartPre", "ExecStart", "ExecStartPost", "ExecReload",
+		"ExecStop", "ExecStopPost", "Restart", "RestartSec", "RemainAfterExit", "SuccessExitStatus",
+		"TimeoutSec", "TimeoutStartSec", "TimeoutStopSec", "WatchdogSec", "NotifyAccess", "KillMode", "KillSignal",
+		"LimitNOFILE", "LimitNPROC", "LimitCORE", "Nice", "UMask", "MemoryMax", "CPUQuota",
+		"StandardInput", "StandardOutput", "StandardError", "SyslogIdentifier",
+		"RuntimeDirectory", "StateDirectory", "LogsDirectory", "ConfigurationDirectory",
+		"AmbientCapabilities", "CapabilityBoundingSet", "NoNewPrivileges", "PrivateTmp", "ProtectSystem",
+		"ProtectHome", "ReadWritePaths",
+	},
+	SectionTimer: {
+		"OnCalendar", "OnActiveSec", "OnBootSec", "OnStartupSec", "OnUnitActiveSec", "OnUnitInactiveSec", "Unit",
+		"Persistent", "AccuracySec", "RandomizedDelaySec", "FixedRandomDelay", "WakeSystem", "RemainAfterElapse",
+	},
+	SectionInstall: {"WantedBy", "RequiredBy", "Alias", "Also", "DefaultInstance"},
+}
+
+// unitSectionParams are the params of systemctl-file holding the directives of each section.
+var unitSectionParams = map[string]string{"unit": SectionUnit, "service": SectionService, "timer": SectionTimer, "install": SectionInstall}
+
+// SystemctlService is a unit file bios writes, like a service or a timer. Its sections and directives are kept
+// in a commands.UnitFile, where a key can be repeated, like Environment or ExecStartPre.
 type SystemctlService struct {
-	Name        string
-	Description string
-	ExecStart   string
-	Restart     string
+	Name string
+	commands.UnitFile
 }
 
 // NewSystemctlService creates a new SystemctlService instance.
 func NewSystemctlService(name, description, execStart, restart string) *SystemctlService {
-	return &SystemctlService{
-		Name:        name,
-		Description: description,
-		ExecStart:   execStart,
-		Restart:     restart,
+	s := &SystemctlService{Name: name}
+	s.addSections(SectionUnit, SectionService, SectionInstall)
+	if description != "" {
+		s.Set(SectionUnit, "Description", description)
+	}
+	if execStart != "" {
+		s.Set(SectionService, "ExecStart", execStart)
 	}
+	if restart != "" {
+		s.Set(SectionService, "Restart", restart)
+	}
+	s.Set(SectionInstall, "WantedBy", "multi-user.target")
+	return s
 }
 
-// GenerateServiceFile generates a systemd service file based on the SystemctlService configuration.
-func (s *SystemctlService) GenerateServiceFile(tmpPath string) (string, error) {
-	content := fmt.Sprintf(`[Unit]
-Description=%s
+// addSections adds the sections the unit doesn't have yet, so that they are written in this order whatever
+// order their directives are set in. Render leaves out the sections that have no directives.
+func (s *SystemctlService) addSections(names ...string) {
+	have := s.Sections()
+	for _, name := range names {
+		if !contains(have, name) {
+			s.Lines = append(s.Lines, &commands.UnitLine{Kind: commands.LineSection, Section: name})
+		}
+	}
+}
+
+// unitTypes are the extensions of the unit files, a name without one is a service.
+var unitTypes = []string{".service", ".timer", ".socket", ".target", ".path", ".mount"}
 
-[Service]
-ExecStart=%s
-Restart=%s
+// FileName is the name of the unit file, like driver.service.
+func (s *SystemctlService) FileName() string {
+	if contains(unitTypes, filepath.Ext(s.Name)) {
+		return s.Name
+	}
+	return fmt.Sprintf("%s.service", s.Name)
+}
 
-[Install]
-WantedBy=multi-user.target`, s.Description, s.ExecStart, s.Restart)
+// Warnings reports the directives that aren't in unitDirectives.
+func (s *SystemctlService) Warnings() []string {
+	var warnings []string
+	for _, section := range s.Sections() {
+		for _, d := range s.Directives(section) {
+			if warning := directiveWarning(section, d.Key); warning != "" {
+				warnings = append(warnings, warning)
+			}
+		}
+	}
+	return warnings
+}
+
+// directiveWarning reports a directive that isn't in unitDirectives, "" when it is.
+func directiveWarning(section, key string) string {
+	known, ok := unitDirectives[section]
+	if !ok || contains(known, key) {
+		return ""
+	}
+	return fmt.Sprintf("unknown directive %s in [%s]", key, section)
+}
+
+var directiveKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+
+// Render checks the directives and writes the unit file, with a blank line between the sections.
+func (s *SystemctlService) Render() (string, error) {
+	u := &commands.UnitFile{}
+	for _, section := range s.Sections() {
+		for _, d := range s.Directives(section) {
+			if err := checkDirective(section, d.Key, d.Value); err != nil {
+				return "", err
+			}
+			u.Append(section, d.Key, d.Value)
+		}
+	}
+	return u.String(), nil
+}
 
-	serviceFilePath := filepath.Join(tmpPath, fmt.Sprintf("%s.service", s.Name))
-	err := ioutil.WriteFile(serviceFilePath, []byte(content), 0644)
+// checkDirective fails on keys that aren't valid, and on values that would change the meaning of the file:
+// a newline would start a new directive, and a trailing backslash would continue the line.
+func checkDirective(section, key, value string) error {
+	if !directiveKey.MatchString(key) {
+		return fmt.Errorf("invalid directive %q in [%s]", key, section)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("the value of %s in [%s] has a newline", key, section)
+	}
+	if strings.HasSuffix(value, "\\") {
+		return fmt.Errorf("the value of %s in [%s] ends with a backslash", key, section)
+	}
+	return nil
+}
+
+// GenerateServiceFile generates a systemd service file based on the SystemctlService configuration.
+func (s *SystemctlService) GenerateServiceFile(tmpPath string) (string, error) {
+	content, err := s.Render()
+	if err != nil {
+		return "", err
+	}
+	serviceFilePath := filepath.Join(tmpPath, s.FileName())
+	err = os.WriteFile(serviceFilePath, []byte(content), 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed to write service file: %w", err)
 	}
@@ -92,6 +507,112 @@ func MoveServiceFile(serviceFilePath, destinationPath string) error {
 	return nil
 }
 
+// serviceFromParams builds the service from the params of systemctl-file. The directives of each section are
+// given as a map in unit:, service: and install:, a list value is a repeated directive, and an Environment map
+// is a directive for each variable:
+//
+//	service:
+//	  ExecStart: /opt/driver/app
+//	  ExecStartPre: [/bin/mkdir -p /data, /bin/chown app /data]
+//	  Environment: {PORT: "1660"}
+//
+// description, ExecStart and Restart are the short form of the same directives in their section. Without
+// install:, the service is WantedBy=multi-user.target. It also returns the warnings about unknown directives.
+func serviceFromParams(params map[string]interface{}) (*SystemctlService, []string, error) {
+	name := trimNewline(paramString(params, "name"))
+	if name == "" {
+		return nil, nil, fmt.Errorf("systemctl-file requires a name")
+	}
+	if err := checkFileName("name", name); err != nil {
+		return nil, nil, err
+	}
+	service := NewSystemctlService(name, paramString(params, "description"), paramString(params, "ExecStart"), paramString(params, "Restart"))
+	changes, err := unitChanges(params, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if params["install"] != nil {
+		// replaces the default WantedBy
+		service.Remove(SectionInstall, "WantedBy")
+	}
+	for _, c := range changes {
+		service.Set(c.section, c.key, c.values...)
+	}
+	return service, service.Warnings(), nil
+}
+
+// directiveOrder returns the keys of the directives in the order of unitDirectives, then the others by name.
+func directiveOrder(section string, directives map[string]interface{}) []string {
+	keys := make([]string, 0, len(directives))
+	for key := range directives {
+		keys = append(keys, key)
+	}
+	rank := func(key string) int {
+		for i, known := range unitDirectives[section] {
+			if known == key {
+				return i
+			}
+		}
+		return len(unitDirectives[section])
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ri, rj := rank(keys[i]), rank(keys[j]); ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// directiveValues returns the values of a directive given as a single value, a list of them, or for
+// Environment, a map of the variables.
+func directiveValues(key string, value interface{}) ([]string, error) {
+	switch t := value.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		var values []string
+		for _, item := range t {
+			if _, ok := item.(map[string]interface{}); ok {
+				return nil, fmt.Errorf("expected a value or a list of values")
+			}
+			values = append(values, expr.ToString(item))
+		}
+		return values, nil
+	case map[string]interface{}:
+		if key != "Environment" {
+			return nil, fmt.Errorf("only Environment can be a map")
+		}
+		names := make([]string, 0, len(t))
+		for name := range t {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var values []string
+		for _, name := range names {
+			values = append(values, quoteEnvironment(name, expr.ToString(t[name])))
+		}
+		return values, nil
+	}
+	return []string{expr.ToString(value)}, nil
+}
+
+// quoteEnvironment writes a variable of an Environment directive, quoted so the value can have spaces, quotes
+// and backslashes, with % escaped so systemd doesn't expand it as a specifier.
+func quoteEnvironment(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace(value)
+	return fmt.Sprintf(`"%s=%s"`, name, value)
+}
+
+// dirParam returns the dir of the params with the key resolved against the workdir, which it is by default.
+func dirParam(ctx context.Context, params map[string]interface{}, key string) string {
+	dir := paramString(params, key)
+	if dir == "" {
+		dir = "."
+	}
+	return resolvePath(ctx, dir)
+}
+
 func trimNewline(s string) string {
 	return strings.TrimSuffix(s, "\n")
 }
@@ -0,0 +1,169 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/execute"
+	"github.com/NubeIO/bios-cli/libs/execute/commands"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeSystemd records the calls made to it, and fails the ones of the unit named failing.
+type fakeSystemd struct {
+	calls  []string
+	timers []commands.TimerInfo
+}
+
+func (f *fakeSystemd) call(format string, a ...interface{}) error {
+	call := fmt.Sprintf(format, a...)
+	f.calls = append(f.calls, call)
+	if strings.HasSuffix(call, " failing") {
+		return fmt.Errorf("exit status 1")
+	}
+	return nil
+}
+
+func (f *fakeSystemd) WithContext(context.Context) commands.Commands { return f }
+
+func (f *fakeSystemd) Run(*commands.CommandBody) *execute.Response { return nil }
+
+func (f *fakeSystemd) Uptime(...int) (*commands.UptimeInfo, error) { return nil, nil }
+
+func (f *fakeSystemd) SystemdStatus(unit string) (*commands.StatusResp, error) {
+	return &commands.StatusResp{Status: "active", IsActive: true}, f.call("status %s", unit)
+}
+
+func (f *fakeSystemd) SystemdCommand(unit, commandType string) error {
+	return f.call("%s %s", commandType, unit)
+}
+
+func (f *fakeSystemd) SystemdDaemonReload() error { return f.call("daemon-reload") }
+
+func (f *fakeSystemd) SystemdShow(unit, property string) (string, error) {
+	return "", f.call("show %s %s", property, unit)
+}
+
+func (f *fakeSystemd) SystemdIsEnabled(unit string) (bool, error) {
+	return false, f.call("is-enabled %s", unit)
+}
+
+func (f *fakeSystemd) SystemdIsActive(unit string) (*commands.StatusResp, error) {
+	return &commands.StatusResp{Status: "inactive"}, f.call("is-active %s", unit)
+}
+
+func (f *fakeSystemd) SystemdTimers(units ...string) ([]commands.TimerInfo, error) {
+	return f.timers, f.call("timers %s", strings.Join(units, " "))
+}
+
+func TestHandleSystemctl(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		calls  []string
+		want   interface{}
+		err    string
+	}{
+		{
+			name:   "action then status",
+			params: map[string]interface{}{"action": "restart", "unit": "driver"},
+			calls:  []string{"restart driver", "status driver"},
+			want:   &commands.StatusResp{Status: "active", IsActive: true},
+		},
+		{
+			name:   "status",
+			params: map[string]interface{}{"action": "status", "unit": "driver\n"},
+			calls:  []string{"status driver"},
+			want:   &commands.StatusResp{Status: "active", IsActive: true},
+		},
+		{
+			name:   "is-active",
+			params: map[string]interface{}{"action": "is-active", "unit": "driver"},
+			calls:  []string{"is-active driver"},
+			want:   &commands.StatusResp{Status: "inactive"},
+		},
+		{
+			name:   "daemon-reload",
+			params: map[string]interface{}{"action": "daemon-reload"},
+			calls:  []string{"daemon-reload"},
+		},
+		{
+			name:   "failed action",
+			params: map[string]interface{}{"action": "start", "unit": "failing"},
+			calls:  []string{"start failing"},
+			err:    "failed to start failing: exit status 1",
+		},
+		{
+			name:   "unknown action",
+			params: map[string]interface{}{"action": "reboot", "unit": "driver"},
+			err:    `unknown systemctl action "reboot", try: status, is-active, daemon-reload, start, stop, restart, enable, disable, mask, unmask, reset-failed`,
+		},
+		{
+			name:   "no unit",
+			params: map[string]interface{}{"action": "stop"},
+			err:    "systemctl stop requires a unit",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			systemd := &fakeSystemd{}
+			bt := NewBuildTool()
+			bt.systemd = systemd
+			got, err := bt.handleSystemctl(context.Background(), tt.params)
+			if !reflect.DeepEqual(systemd.calls, tt.calls) {
+				t.Errorf("got calls %q, want %q", systemd.calls, tt.calls)
+			}
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil && got != nil || tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemctlFileNames(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "driver.service"), []byte("[Service]\nExecStart=/opt/driver\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"driver", "../../tmp/driver", "system/driver"} {
+		t.Run(name, func(t *testing.T) {
+			params := map[string]interface{}{"name": name, "ExecStart": "/opt/driver"}
+			want := fmt.Sprintf("the name %q must be a file name, without / or ..", name)
+			if name == "driver" {
+				want = ""
+			}
+			_, _, createErr := serviceFromParams(params)
+			_, _, _, _, editErr := editUnitFile(params, dir)
+			for op, err := range map[string]error{"create": createErr, "edit": editErr} {
+				if want == "" && err != nil || want != "" && (err == nil || err.Error() != want) {
+					t.Errorf("%s: got error %v, want %q", op, err, want)
+				}
+			}
+		})
+	}
+}
+
+// TestCommands checks that every command runs the handler of its cmd.
+func TestCommands(t *testing.T) {
+	bt := NewBuildTool()
+	for key, command := range bt.Commands {
+		if reflect.ValueOf(command.Func).Pointer() != reflect.ValueOf(bt.CommandMap[key]).Pointer() {
+			t.Errorf("the command %s has the handler of another command", key)
+		}
+	}
+	if command := bt.Commands["systemctl-file"]; command.Name != "systemctl-file" {
+		t.Errorf("the command systemctl-file is named %s", command.Name)
+	}
+}
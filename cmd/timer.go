@@ -0,0 +1,216 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/execute/commands"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Operations of systemctl-timer.
+const (
+	TimerCreate = "create" // write the .timer and the .service it starts, the default
+	TimerList   = "list"   // the timers with their next and last trigger times, only the one of name when it is set
+)
+
+// timerTriggers are the directives of [Timer] that schedule it, a timer needs at least one of them.
+var timerTriggers = []string{"OnCalendar", "OnActiveSec", "OnBootSec", "OnStartupSec", "OnUnitActiveSec", "OnUnitInactiveSec"}
+
+// timerResult is the response of systemctl-timer create.
+type timerResult struct {
+	Timer   *unitFileResult `json:"timer"`
+	Service *unitFileResult `json:"service"`
+	// Changed is set when either file changed, so that systemd needs a daemon-reload
+	Changed bool `json:"changed"`
+}
+
+// timerUnits builds the timer and the service it starts from the params:
+//
+//	name: backup
+//	description: back up the data
+//	ExecStart: /opt/backup/run.sh
+//	timer:
+//	  OnCalendar: Mon..Fri 02:00
+//	  Persistent: true
+//	  RandomizedDelaySec: 10min
+//	service:
+//	  User: backup
+//
+// The service is Type=oneshot and has no [Install], the timer starts it, and the timer is
+// WantedBy=timers.target unless install: is set. unit: and service: are the directives of the service, timer:
+// and install: the ones of the timer.
+func timerUnits(params map[string]interface{}) (*SystemctlService, *SystemctlService, []string, error) {
+	name := strings.TrimSuffix(trimNewline(paramString(params, "name")), ".timer")
+	if name == "" {
+		return nil, nil, nil, fmt.Errorf("systemctl-timer requires a name")
+	}
+	if err := checkFileName("name", name); err != nil {
+		return nil, nil, nil, err
+	}
+	changes, err := unitChanges(params, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	description := paramString(params, "description")
+	if description == "" {
+		description = name
+	}
+	service := &SystemctlService{Name: name}
+	service.Set(SectionUnit, "Description", description)
+	service.Set(SectionService, "Type", "oneshot")
+	if execStart := paramString(params, "ExecStart"); execStart != "" {
+		service.Set(SectionService, "ExecStart", execStart)
+	}
+	timer := &SystemctlService{Name: name + ".timer"}
+	timer.Set(SectionUnit, "Description", fmt.Sprintf("Timer of %s", description))
+	timer.addSections(SectionTimer)
+	if params["install"] == nil {
+		timer.Set(SectionInstall, "WantedBy", "timers.target")
+	}
+	for _, c := range changes {
+		switch c.section {
+		case SectionTimer, SectionInstall:
+			timer.Set(c.section, c.key, c.values...)
+		default:
+			service.Set(c.section, c.key, c.values...)
+		}
+	}
+	if len(service.Get(SectionService, "ExecStart")) == 0 {
+		return nil, nil, nil, fmt.Errorf("systemctl-timer requires the ExecStart of the service")
+	}
+	if len(timer.Get(SectionTimer, "Unit")) == 0 {
+		timer.Set(SectionTimer, "Unit", service.FileName())
+	}
+	scheduled := false
+	for _, d := range timer.Directives(SectionTimer) {
+		if err := checkTimerDirective(d.Key, d.Value); err != nil {
+			return nil, nil, nil, err
+		}
+		scheduled = scheduled || contains(timerTriggers, d.Key)
+	}
+	if !scheduled {
+		return nil, nil, nil, fmt.Errorf("the timer has no trigger, set one of %s in timer:", strings.Join(timerTriggers, ", "))
+	}
+	return timer, service, append(timer.Warnings(), service.Warnings()...), nil
+}
+
+// checkTimerDirective checks the calendar events and the time spans of [Timer].
+func checkTimerDirective(key, value string) error {
+	var err error
+	switch {
+	case key == "OnCalendar":
+		err = commands.ValidateCalendar(value)
+	case strings.HasSuffix(key, "Sec"):
+		err = commands.ValidateTimeSpan(value)
+	case key == "Persistent" || key == "WakeSystem" || key == "RemainAfterElapse":
+		if !contains([]string{"true", "false", "yes", "no", "on", "off", "1", "0"}, strings.ToLower(value)) {
+			err = fmt.Errorf("expected true or false, not %q", value)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s in [%s]: %v", key, SectionTimer, err)
+	}
+	return nil
+}
+
+func (bt *BuildTool) handleSystemctlTimer(ctx context.Context, params interface{}) (interface{}, error) {
+	paramMap, ok := params.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid params for systemctl-timer")
+	}
+	switch op := unitFileOp(paramMap); op {
+	case TimerList:
+		var units []string
+		if name := trimNewline(paramString(paramMap, "name")); name != "" {
+			units = append(units, strings.TrimSuffix(name, ".timer")+".timer")
+		}
+		timers, err := bt.systemd.WithContext(ctx).SystemdTimers(units...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the timers: %v", err)
+		}
+		return timers, nil
+	case TimerCreate:
+		timer, service, warnings, err := timerUnits(paramMap)
+		if err != nil {
+			return nil, err
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(stepStderr(ctx), "warning: %s\n", warning)
+		}
+		location := timerLocation(ctx, paramMap)
+		if err := os.MkdirAll(location, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %v", location, err)
+		}
+		result := &timerResult{}
+		if result.Service, err = writeUnit(ctx, location, service); err != nil {
+			return nil, err
+		}
+		if result.Timer, err = writeUnit(ctx, location, timer); err != nil {
+			return nil, err
+		}
+		result.Changed = result.Service.Changed || result.Timer.Changed
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unknown systemctl-timer operation %q, try: %s or %s", op, TimerCreate, TimerList)
+	}
+}
+
+func (bt *BuildTool) planSystemctlTimer(ctx context.Context, params interface{}) (*Action, error) {
+	paramMap, ok := params.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid params for systemctl-timer")
+	}
+	switch op := unitFileOp(paramMap); op {
+	case TimerList:
+		return &Action{Description: "list the timers with their next and last trigger times"}, nil
+	case TimerCreate:
+		timer, service, _, err := timerUnits(paramMap)
+		if err != nil {
+			return nil, err
+		}
+		var contents []string
+		for _, unit := range []*SystemctlService{timer, service} {
+			content, err := unit.Render()
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, fmt.Sprintf("# %s\n%s", unit.FileName(), content))
+		}
+		location := timerLocation(ctx, paramMap)
+		return &Action{
+			Description: fmt.Sprintf("write the timer %s and the service %s to %s", timer.FileName(), service.FileName(), location),
+			Files:       []string{filepath.Join(location, timer.FileName()), filepath.Join(location, service.FileName())},
+			Content:     strings.Join(contents, "\n"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown systemctl-timer operation %q, try: %s or %s", op, TimerCreate, TimerList)
+	}
+}
+
+// writeUnit writes the unit file to the location, unless it is up to date.
+func writeUnit(ctx context.Context, location string, unit *SystemctlService) (*unitFileResult, error) {
+	content, err := unit.Render()
+	if err != nil {
+		return nil, err
+	}
+	result := &unitFileResult{File: filepath.Join(location, unit.FileName()), Warnings: unit.Warnings()}
+	if previous, _ := os.ReadFile(result.File); string(previous) == content {
+		return result, nil
+	}
+	if err := writeUnitFile(result.File, content); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(stepStdout(ctx), "Systemctl unit file %s written\n", result.File)
+	result.Changed = true
+	return result, nil
+}
+
+// timerLocation is the dir the units are written to, DefaultUnitDir by default.
+func timerLocation(ctx context.Context, params map[string]interface{}) string {
+	if paramString(params, "location") == "" {
+		return DefaultUnitDir
+	}
+	return dirParam(ctx, params, "location")
+}
@@ -0,0 +1,188 @@
+package commander
+
+import (
+	"context"
+	"github.com/NubeIO/bios-cli/libs/execute/commands"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimerUnits(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]interface{}
+		timer   string
+		service string
+		err     string
+	}{
+		{
+			name: "calendar list",
+			params: map[string]interface{}{
+				"name":      "backup",
+				"ExecStart": "/opt/backup/run.sh",
+				"timer":     map[string]interface{}{"OnCalendar": []interface{}{"Mon..Fri 02:00", "Sat 04:00"}, "Persistent": true},
+				"service":   map[string]interface{}{"User": "backup"},
+			},
+			timer: `[Unit]
+Description=Timer of backup
+
+[Timer]
+OnCalendar=Mon..Fri 02:00
+OnCalendar=Sat 04:00
+Persistent=true
+Unit=backup.service
+
+[Install]
+WantedBy=timers.target
+`,
+			service: `[Unit]
+Description=backup
+
+[Service]
+Type=oneshot
+ExecStart=/opt/backup/run.sh
+User=backup
+`,
+		},
+		{
+			name: "own install and unit",
+			params: map[string]interface{}{
+				"name":        "cleanup.timer",
+				"description": "clean up the logs",
+				"timer":       map[string]interface{}{"OnBootSec": "5min", "OnUnitActiveSec": "1h", "Unit": "logs.service"},
+				"service":     map[string]interface{}{"ExecStart": "/opt/cleanup"},
+				"install":     map[string]interface{}{"WantedBy": "multi-user.target"},
+			},
+			timer: `[Unit]
+Description=Timer of clean up the logs
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=1h
+Unit=logs.service
+
+[Install]
+WantedBy=multi-user.target
+`,
+			service: `[Unit]
+Description=clean up the logs
+
+[Service]
+Type=oneshot
+ExecStart=/opt/cleanup
+`,
+		},
+		{
+			name:   "no name",
+			params: map[string]interface{}{"ExecStart": "/opt/backup/run.sh", "timer": map[string]interface{}{"OnCalendar": "daily"}},
+			err:    "systemctl-timer requires a name",
+		},
+		{
+			name:   "name with a path",
+			params: map[string]interface{}{"name": "../../tmp/x", "ExecStart": "/opt/backup/run.sh", "timer": map[string]interface{}{"OnCalendar": "daily"}},
+			err:    `the name "../../tmp/x" must be a file name, without / or ..`,
+		},
+		{
+			name:   "no ExecStart",
+			params: map[string]interface{}{"name": "backup", "timer": map[string]interface{}{"OnCalendar": "daily"}},
+			err:    "systemctl-timer requires the ExecStart of the service",
+		},
+		{
+			name:   "no trigger",
+			params: map[string]interface{}{"name": "backup", "ExecStart": "/opt/backup/run.sh", "timer": map[string]interface{}{"Persistent": true}},
+			err:    "the timer has no trigger, set one of OnCalendar, OnActiveSec, OnBootSec, OnStartupSec, OnUnitActiveSec, OnUnitInactiveSec in timer:",
+		},
+		{
+			name:   "invalid calendar",
+			params: map[string]interface{}{"name": "backup", "ExecStart": "/opt/backup/run.sh", "timer": map[string]interface{}{"OnCalendar": []interface{}{"daily", "25:00"}}},
+			err:    `OnCalendar in [Timer]: invalid calendar event "25:00": invalid hour "25", expected * or 0 to 23`,
+		},
+		{
+			name:   "invalid time span",
+			params: map[string]interface{}{"name": "backup", "ExecStart": "/opt/backup/run.sh", "timer": map[string]interface{}{"OnBootSec": "soon"}},
+			err:    `OnBootSec in [Timer]: invalid time span "soon", expected like 30s, 5min or 1h 30min`,
+		},
+		{
+			name:   "invalid bool",
+			params: map[string]interface{}{"name": "backup", "ExecStart": "/opt/backup/run.sh", "timer": map[string]interface{}{"OnCalendar": "daily", "Persistent": "always"}},
+			err:    `Persistent in [Timer]: expected true or false, not "always"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timer, service, _, err := timerUnits(tt.params)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, unit := range []struct {
+				unit *SystemctlService
+				want string
+			}{{timer, tt.timer}, {service, tt.service}} {
+				got, err := unit.unit.Render()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != unit.want {
+					t.Errorf("got %s\n%s\nwant\n%s", unit.unit.FileName(), got, unit.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleSystemctlTimer(t *testing.T) {
+	dir := t.TempDir()
+	bt := NewBuildTool()
+	params := map[string]interface{}{
+		"name":      "backup",
+		"location":  dir,
+		"ExecStart": "/opt/backup/run.sh",
+		"timer":     map[string]interface{}{"OnCalendar": "daily"},
+	}
+	create := func() *timerResult {
+		t.Helper()
+		got, err := bt.handleSystemctlTimer(context.Background(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.(*timerResult)
+	}
+	result := create()
+	if !result.Changed || !result.Timer.Changed || !result.Service.Changed {
+		t.Errorf("got %+v, want both files changed", result)
+	}
+	if result.Timer.File != filepath.Join(dir, "backup.timer") || result.Service.File != filepath.Join(dir, "backup.service") {
+		t.Errorf("got files %s and %s", result.Timer.File, result.Service.File)
+	}
+	if result := create(); result.Changed || result.Timer.Changed || result.Service.Changed {
+		t.Errorf("got %+v, want nothing changed", result)
+	}
+	// only the file that changed
+	params["service"] = map[string]interface{}{"User": "backup"}
+	if result := create(); !result.Changed || result.Timer.Changed || !result.Service.Changed {
+		t.Errorf("got %+v, want the service changed", result)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "backup.service")); string(b) != "[Unit]\nDescription=backup\n\n[Service]\nType=oneshot\nExecStart=/opt/backup/run.sh\nUser=backup\n" {
+		t.Errorf("got the service\n%s", b)
+	}
+
+	next := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	systemd := &fakeSystemd{timers: []commands.TimerInfo{{Unit: "backup.timer", Activates: "backup.service", Next: &next}}}
+	bt.systemd = systemd
+	got, err := bt.handleSystemctlTimer(context.Background(), map[string]interface{}{"op": TimerList, "name": "backup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, systemd.timers) || !reflect.DeepEqual(systemd.calls, []string{"timers backup.timer"}) {
+		t.Errorf("got %+v with calls %q", got, systemd.calls)
+	}
+}
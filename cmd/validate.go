@@ -0,0 +1,460 @@
+package commander
+
+import (
+	"fmt"
+	"github.com/NubeIO/bios-cli/libs/expr"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Issue is a problem found in a flow file by Validate.
+type Issue struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// HasErrors reports whether any of the issues is an error, rather than a warning.
+func HasErrors(issues []Issue) bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinNames are the names every expression of a flow can reference, see flowScope.
+var builtinNames = []string{"steps", "vars", "args", "flow", "env"}
+
+// validator checks a flow file, from its yaml nodes so that issues have a line and column.
+type validator struct {
+	bt     *BuildTool
+	file   string
+	names  map[string]bool // the args, vars and registered vars of the flow
+	issues []Issue
+}
+
+// Validate checks a flow file without running it: the keys of the flow and its steps, the cmd of every step
+// and its params against the params of the command, and that every ${...} references a declared arg or var.
+func (bt *BuildTool) Validate(filename string) []Issue {
+	v := &validator{bt: bt, file: filename, names: make(map[string]bool)}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		v.errorf(nil, "%v", err)
+		return v.issues
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		v.errorf(nil, "%v", err)
+		return v.issues
+	}
+	if len(doc.Content) == 0 {
+		v.errorf(nil, "the file is empty")
+		return v.issues
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		v.errorf(root, "a flow must be a map with args, vars and steps")
+		return v.issues
+	}
+
+	// the flow with its includes merged in, to know every name the steps can reference
+	flow, err := loadFlowFile(filename, nil)
+	if err != nil {
+		v.errorf(nil, "%v", err)
+		return v.issues
+	}
+	if err := flow.checkArgs(); err != nil {
+		v.errorf(nil, "%v", err)
+	}
+	if _, _, err := flow.stepGraph(); err != nil {
+		v.errorf(nil, "%v", err)
+	}
+	for _, name := range flow.argNames() {
+		v.names[name] = true
+	}
+	for _, variable := range flow.Vars {
+		v.names[variable.Name] = true
+	}
+	for _, step := range allSteps(flow) {
+		for name := range step.Register {
+			v.names[name] = true
+		}
+	}
+
+	v.checkKeys(root, reflect.TypeOf(BuildYAML{}), "the flow")
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "steps", "finally", "always":
+			v.checkSteps(value)
+		case "outputs", "env", "workdir":
+			v.checkRefs(value, false)
+		case "onError":
+			if err := checkOnError(value.Value); err != nil {
+				v.errorf(value, "%v", err)
+			}
+		case "timeout":
+			v.checkDuration(value, "timeout", "the flow")
+		case "args":
+			for _, arg := range value.Content {
+				if arg.Kind == yaml.MappingNode {
+					v.checkKeys(arg, reflect.TypeOf(Arg{}), "an arg")
+				}
+			}
+		}
+	}
+	v.checkUnusedArgs(root, flow)
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		if v.issues[i].Line != v.issues[j].Line {
+			return v.issues[i].Line < v.issues[j].Line
+		}
+		return v.issues[i].Column < v.issues[j].Column
+	})
+	return v.issues
+}
+
+func (v *validator) add(node *yaml.Node, severity, format string, a ...interface{}) {
+	issue := Issue{File: v.file, Severity: severity, Message: fmt.Sprintf(format, a...)}
+	if node != nil {
+		issue.Line = node.Line
+		issue.Column = node.Column
+	}
+	v.issues = append(v.issues, issue)
+}
+
+func (v *validator) errorf(node *yaml.Node, format string, a ...interface{}) {
+	v.add(node, SeverityError, format, a...)
+}
+
+func (v *validator) warnf(node *yaml.Node, format string, a ...interface{}) {
+	v.add(node, SeverityWarning, format, a...)
+}
+
+// checkKeys reports the keys of a map node that aren't yaml keys of the struct type.
+func (v *validator) checkKeys(node *yaml.Node, t reflect.Type, what string) {
+	known := yamlKeys(t)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if key := node.Content[i]; !known[key.Value] {
+			v.errorf(key, "unknown key %q in %s", key.Value, what)
+		}
+	}
+}
+
+func (v *validator) checkSteps(node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		v.errorf(node, "expected a list of steps")
+		return
+	}
+	for _, step := range node.Content {
+		v.checkStep(step)
+	}
+}
+
+func (v *validator) checkStep(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "expected a step, with a name, cmd and params")
+		return
+	}
+	step := mapValues(node)
+	name := "a step"
+	if n := step["name"]; n != nil {
+		name = fmt.Sprintf("step %q", n.Value)
+	}
+	v.checkKeys(node, stepType, name)
+	v.checkDuration(step["timeout"], "timeout", name)
+	if retry := step["retry"]; retry != nil && retry.Kind == yaml.MappingNode {
+		v.checkKeys(retry, reflect.TypeOf(Retry{}), "retry of "+name)
+		policy := mapValues(retry)
+		v.checkDuration(policy["delay"], "retry delay", name)
+		v.checkDuration(policy["maxDelay"], "retry maxDelay", name)
+	}
+	_, foreach := step["foreach"]
+
+	cmd := step["cmd"]
+	switch {
+	case cmd == nil:
+		v.errorf(node, "%s has no cmd", name)
+	case v.bt.CommandMap[cmd.Value] == nil:
+		v.errorf(cmd, "unknown command %q in %s", cmd.Value, name)
+	case commandParams[cmd.Value] != nil:
+		v.checkParams(node, step["params"], cmd.Value, commandParams[cmd.Value])
+	}
+	for _, key := range []string{"params", "env", "workdir"} {
+		if node := step[key]; node != nil {
+			v.checkRefs(node, foreach)
+		}
+	}
+	if cond := step["if"]; cond != nil {
+		v.checkExpr(cond, cond.Value, foreach, false)
+	}
+	if foreach {
+		v.checkRefs(step["foreach"], false)
+	}
+	if rollback := step["rollback"]; rollback != nil {
+		v.checkSteps(rollback)
+	}
+}
+
+// checkDuration reports a duration, like the timeout of a step, that the run would fail on.
+func (v *validator) checkDuration(node *yaml.Node, what, of string) {
+	if node == nil || node.Value == "" {
+		return
+	}
+	if _, err := time.ParseDuration(node.Value); err != nil {
+		v.errorf(node, "invalid %s %q of %s: %v", what, node.Value, of, err)
+	}
+}
+
+// checkParams checks the params node of a step against the params of its command.
+func (v *validator) checkParams(step, node *yaml.Node, cmd string, params *Params) {
+	if node == nil || node.Tag == "!!null" {
+		if len(params.Kinds) > 0 {
+			v.errorf(step, "%s requires params", cmd)
+		}
+		return
+	}
+	if len(params.Kinds) == 0 {
+		v.warnf(node, "%s takes no params", cmd)
+		return
+	}
+	kind := nodeKind(node)
+	if !contains(params.Kinds, kind) {
+		v.errorf(node, "%s expects params of %s, not a %s", cmd, strings.Join(params.Kinds, " or "), kind)
+		return
+	}
+	switch kind {
+	case "string":
+		if fields := strings.Fields(node.Value); len(params.Ops) > 0 && len(fields) > 0 {
+			v.checkOp(node, cmd, fields[0], params.Ops)
+		}
+	case "list":
+		if len(params.Ops) > 0 && len(node.Content) > 0 {
+			v.checkOp(node.Content[0], cmd, node.Content[0].Value, params.Ops)
+		}
+		for _, item := range node.Content {
+			if len(params.Items) > 0 && !hasRef(item.Value) && !contains(params.Items, item.Value) {
+				v.errorf(item, "%s does not support %q, try: %s", cmd, item.Value, strings.Join(params.Items, ", "))
+			}
+		}
+	case "map":
+		values := mapValues(node)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := params.Fields[key.Value]
+			if !ok {
+				if !params.Open {
+					v.errorf(key, "unknown param %q for %s", key.Value, cmd)
+				}
+				continue
+			}
+			// a ${...} may resolve to any kind of value
+			if kind := nodeKind(value); field.Kind != "any" && kind != field.Kind && !(kind == "string" && hasRef(value.Value)) {
+				v.errorf(value, "param %q of %s should be a %s, not a %s", key.Value, cmd, field.Kind, kind)
+			}
+			if field.Check != nil && value.Kind != yaml.MappingNode {
+				v.checkValues(key.Value, value, field.Check)
+			}
+			if value.Kind == yaml.MappingNode {
+				for j := 0; j+1 < len(value.Content); j += 2 {
+					k := value.Content[j]
+					if len(field.Keys) > 0 && !hasRef(k.Value) && !contains(field.Keys, k.Value) {
+						v.warnf(k, "unknown key %q in the param %q of %s", k.Value, key.Value, cmd)
+					}
+					if field.Check != nil {
+						v.checkValues(k.Value, value.Content[j+1], field.Check)
+					}
+				}
+			}
+		}
+		for _, required := range params.Required {
+			if _, ok := values[required]; !ok {
+				v.errorf(node, "%s requires the param %q", cmd, required)
+			}
+		}
+	}
+}
+
+// checkValues reports the values of a field, or of the key of a map field, that check fails on, each item of a list. Values
+// with a ${...} are only known once they are resolved.
+func (v *validator) checkValues(key string, value *yaml.Node, check func(key, value string) error) {
+	items := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		items = value.Content
+	}
+	for _, item := range items {
+		if item.Kind != yaml.ScalarNode || hasRef(item.Value) {
+			continue
+		}
+		if err := check(key, item.Value); err != nil {
+			v.errorf(item, "%v", err)
+		}
+	}
+}
+
+func (v *validator) checkOp(node *yaml.Node, cmd, op string, ops []string) {
+	if !hasRef(op) && !contains(ops, op) {
+		v.errorf(node, "unknown %s operation %q, try: %s", cmd, op, strings.Join(ops, ", "))
+	}
+}
+
+// checkRefs checks the ${...} references in every string of the node.
+func (v *validator) checkRefs(node *yaml.Node, foreach bool) {
+	if node.Kind == yaml.ScalarNode {
+		refs, err := expr.Refs(node.Value)
+		if err != nil {
+			v.errorf(node, "%v", err)
+			return
+		}
+		for _, ref := range refs {
+			// a reference with a :- default may be undefined
+			v.checkExpr(node, ref.Expr, foreach, ref.Default != nil)
+		}
+		return
+	}
+	for _, child := range node.Content {
+		v.checkRefs(child, foreach)
+	}
+}
+
+// checkExpr checks that the expression parses, only calls builtin functions and only references names the
+// flow has, unless mayBeUndefined.
+func (v *validator) checkExpr(node *yaml.Node, src string, foreach, mayBeUndefined bool) {
+	parsed, err := expr.Parse(src)
+	if err != nil {
+		v.errorf(node, "invalid expression %s: %v", src, err)
+		return
+	}
+	for _, fn := range expr.Calls(parsed) {
+		if _, ok := expr.Builtins[fn]; !ok {
+			v.errorf(node, "unknown function %s() in %s", fn, src)
+		}
+	}
+	if mayBeUndefined {
+		return
+	}
+	for _, name := range expr.Names(parsed) {
+		if v.names[name] || contains(builtinNames, name) || (foreach && (name == "item" || name == "index")) {
+			continue
+		}
+		if name == "item" || name == "index" {
+			v.errorf(node, "%s is only set in steps with foreach", name)
+			continue
+		}
+		v.errorf(node, "undefined reference %q in %s: declare it in args or vars, or register it from a step", name, src)
+	}
+}
+
+// checkUnusedArgs warns about declared args that nothing references.
+func (v *validator) checkUnusedArgs(root *yaml.Node, flow *BuildYAML) {
+	args := mapValues(root)["args"]
+	if args == nil || args.Kind != yaml.SequenceNode {
+		return
+	}
+	used := make(map[string]bool)
+	var collect func(value interface{})
+	collect = func(value interface{}) {
+		switch t := value.(type) {
+		case string:
+			refs, _ := expr.Refs(t)
+			for _, ref := range refs {
+				if parsed, err := expr.Parse(ref.Expr); err == nil {
+					for _, name := range expr.Names(parsed) {
+						used[name] = true
+					}
+				}
+			}
+		case []interface{}:
+			for _, item := range t {
+				collect(item)
+			}
+		case map[string]interface{}:
+			for _, item := range t {
+				collect(item)
+			}
+		}
+	}
+	for _, step := range allSteps(flow) {
+		collect(step.Params)
+		collect(step.Foreach)
+		collect(step.Workdir)
+		for _, val := range step.Env {
+			collect(val)
+		}
+		if parsed, err := expr.Parse(step.If); err == nil && step.If != "" {
+			for _, name := range expr.Names(parsed) {
+				used[name] = true
+			}
+		}
+	}
+	for _, variable := range flow.Vars {
+		collect(variable.Value)
+	}
+	for _, output := range flow.Outputs {
+		collect(output)
+	}
+	for _, val := range flow.Env {
+		collect(val)
+	}
+	collect(flow.Workdir)
+	for _, arg := range args.Content {
+		name := arg
+		if arg.Kind == yaml.MappingNode && mapValues(arg)["name"] != nil {
+			name = mapValues(arg)["name"]
+		}
+		if !used[name.Value] {
+			v.warnf(name, "arg %q is not used", name.Value)
+		}
+	}
+}
+
+// allSteps returns the steps, finally steps and rollback steps of the flow.
+func allSteps(flow *BuildYAML) []BuildStep {
+	var steps []BuildStep
+	var add func(list []BuildStep)
+	add = func(list []BuildStep) {
+		for _, step := range list {
+			steps = append(steps, step)
+			add(step.Rollback)
+		}
+	}
+	add(flow.Steps)
+	add(flow.finallySteps())
+	return steps
+}
+
+// mapValues returns the values of a map node by key.
+func mapValues(node *yaml.Node) map[string]*yaml.Node {
+	values := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		values[node.Content[i].Value] = node.Content[i+1]
+	}
+	return values
+}
+
+// nodeKind is the kind of params a node is: string, list or map.
+func nodeKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "list"
+	case yaml.MappingNode:
+		return "map"
+	}
+	return "string"
+}
+
+func hasRef(s string) bool {
+	return strings.Contains(s, "${")
+}
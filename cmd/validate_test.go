@@ -0,0 +1,183 @@
+package commander
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		flow string
+		want []Issue
+	}{
+		{
+			name: "valid",
+			flow: `
+args: [tag]
+vars: [{name: dir, value: /data}]
+steps:
+  - name: get
+    cmd: http
+    params: {method: GET, url: "https://example.com/${tag}"}
+    register: {status: $.status}
+  - name: each
+    cmd: bash
+    foreach: [a, b]
+    params: "echo ${item} ${index} ${status} ${dir} ${env.HOME} ${maybe:-none}"
+finally:
+  - {name: report, cmd: bash, if: flow.failed, params: "echo ${flow.error}"}`,
+		},
+		{
+			name: "unknown keys",
+			flow: `
+name: check
+colour: red
+args:
+  - name: tag
+    kind: string
+steps:
+  - name: build
+    cmd: bash
+    params: "echo ${tag}"
+    wait: true
+    retry: {attempts: 2, every: 1s}`,
+			want: []Issue{
+				{Line: 3, Column: 1, Severity: SeverityError, Message: `unknown key "colour" in the flow`},
+				{Line: 6, Column: 5, Severity: SeverityError, Message: `unknown key "kind" in an arg`},
+				{Line: 11, Column: 5, Severity: SeverityError, Message: `unknown key "wait" in step "build"`},
+				{Line: 12, Column: 26, Severity: SeverityError, Message: `unknown key "every" in retry of step "build"`},
+			},
+		},
+		{
+			name: "flow settings",
+			flow: `
+onError: contnue
+timeout: 10 m
+steps:
+  - name: build
+    cmd: bash
+    params: "true"
+    timeout: soon
+    retry: {attempts: 3, delay: 2secs, maxDelay: 1m}`,
+			want: []Issue{
+				{Line: 2, Column: 10, Severity: SeverityError, Message: `unknown onError "contnue", try: stop or continue`},
+				{Line: 3, Column: 10, Severity: SeverityError, Message: `invalid timeout "10 m" of the flow: time: unknown unit " m" in duration "10 m"`},
+				{Line: 8, Column: 14, Severity: SeverityError, Message: `invalid timeout "soon" of step "build": time: invalid duration "soon"`},
+				{Line: 9, Column: 33, Severity: SeverityError, Message: `invalid retry delay "2secs" of step "build": time: unknown unit "secs" in duration "2secs"`},
+			},
+		},
+		{
+			name: "unknown commands",
+			flow: `
+steps:
+  - {name: typo, cmd: sytemctl}
+  - name: none
+finally:
+  - {name: cleanup, cmd: rm}`,
+			want: []Issue{
+				{Line: 3, Column: 23, Severity: SeverityError, Message: `unknown command "sytemctl" in step "typo"`},
+				{Line: 4, Column: 5, Severity: SeverityError, Message: `step "none" has no cmd`},
+				{Line: 6, Column: 26, Severity: SeverityError, Message: `unknown command "rm" in step "cleanup"`},
+			},
+		},
+		{
+			name: "params",
+			flow: `
+steps:
+  - name: restart
+    cmd: systemctl
+    params: {action: reboot, unit: driver}
+  - name: list
+    cmd: dirs
+    params: [explode, /tmp]
+  - name: dirs
+    cmd: dirs
+    params: {path: /tmp}
+  - name: get
+    cmd: http
+  - name: post
+    cmd: http
+    params: {url: /, retries: 3}
+  - name: unit
+    cmd: systemctl-file
+    params: {name: x, service: {ExecStart: /bin/x, Bogus: y}}
+  - name: timer
+    cmd: systemctl-timer
+    params: {name: backup, ExecStart: /bin/backup, timer: {OnCalendar: "Mon..Fry 02:00"}}`,
+			want: []Issue{
+				{Line: 5, Column: 22, Severity: SeverityError, Message: `unknown systemctl action "reboot", try: status, is-active, daemon-reload, start, stop, restart, enable, disable, mask, unmask, reset-failed`},
+				{Line: 8, Column: 14, Severity: SeverityError, Message: `unknown dirs operation "explode", try: mkdir, delete, unzip, mv, rename, walkup, walkdown, listfiles`},
+				{Line: 11, Column: 13, Severity: SeverityError, Message: `dirs expects params of string or list, not a map`},
+				{Line: 12, Column: 5, Severity: SeverityError, Message: `http requires params`},
+				{Line: 16, Column: 13, Severity: SeverityError, Message: `http requires the param "method"`},
+				{Line: 16, Column: 22, Severity: SeverityError, Message: `unknown param "retries" for http`},
+				{Line: 19, Column: 52, Severity: SeverityWarning, Message: `unknown key "Bogus" in the param "service" of systemctl-file`},
+				{Line: 22, Column: 72, Severity: SeverityError, Message: `OnCalendar in [Timer]: invalid calendar event "Mon..Fry 02:00": invalid weekday "Fry"`},
+			},
+		},
+		{
+			name: "references",
+			flow: `
+args: [tag, unused]
+steps:
+  - name: build
+    cmd: bash
+    params: "echo ${tag} ${missing}"
+  - name: item
+    cmd: bash
+    params: "echo ${item}"
+  - name: call
+    cmd: bash
+    params: "echo ${nope(tag)} ${len(tag)}"
+  - name: check
+    cmd: bash
+    if: flow.failed && x
+    params: "echo"`,
+			want: []Issue{
+				{Line: 2, Column: 13, Severity: SeverityWarning, Message: `arg "unused" is not used`},
+				{Line: 6, Column: 13, Severity: SeverityError, Message: `undefined reference "missing" in missing: declare it in args or vars, or register it from a step`},
+				{Line: 9, Column: 13, Severity: SeverityError, Message: `item is only set in steps with foreach`},
+				{Line: 12, Column: 13, Severity: SeverityError, Message: `unknown function nope() in nope(tag)`},
+				{Line: 15, Column: 9, Severity: SeverityError, Message: `undefined reference "x" in flow.failed && x: declare it in args or vars, or register it from a step`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := testFlow(t, tt.flow)
+			got := NewBuildTool().Validate(flow.File)
+			for i := range tt.want {
+				tt.want[i].File = flow.File
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d issues, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if !reflect.DeepEqual(got[i], tt.want[i]) {
+					t.Errorf("got %+v\nwant %+v", got[i], tt.want[i])
+				}
+			}
+			if HasErrors(got) != HasErrors(tt.want) {
+				t.Errorf("HasErrors is %v", HasErrors(got))
+			}
+		})
+	}
+}
+
+// TestSchemaFile checks that the published schema is what `bios schema` prints, run bios schema to update it.
+func TestSchemaFile(t *testing.T) {
+	published, err := os.ReadFile("../schema/flow.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := json.MarshalIndent(NewBuildTool().JSONSchema(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(published) != string(schema)+"\n" {
+		t.Error("schema/flow.schema.json is out of date, run: bios schema > schema/flow.schema.json")
+	}
+}
@@ -0,0 +1,191 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	TypeLine = "line" // a line of output from a step
+	TypeStep = "step" // a step changed status
+)
+
+// maxHistory is the number of events kept to replay to late subscribers.
+const maxHistory = 10000
+
+// Event is a single line of output, or a status change, of a step.
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	RunID  string    `json:"runId,omitempty"`
+	Index  int       `json:"index"`
+	Step   string    `json:"step"`
+	Stream string    `json:"stream,omitempty"` // stdout or stderr
+	Line   string    `json:"line,omitempty"`
+	Status string    `json:"status,omitempty"`
+}
+
+// Broker fans events out to its subscribers, and keeps a history so late subscribers see the whole run.
+type Broker struct {
+	mu      sync.Mutex
+	subs    map[chan Event]struct{}
+	history []Event
+	closed  bool
+}
+
+// NewBroker creates a new Broker.
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[chan Event]struct{})}
+}
+
+// Publish sends the event to all subscribers. Slow subscribers miss events rather than block the flow.
+func (b *Broker) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	if len(b.history) >= maxHistory {
+		b.history = b.history[1:]
+	}
+	b.history = append(b.history, e)
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the events published so far and a channel of the events to come.
+// The channel is closed when the broker is closed or cancel is called.
+func (b *Broker) Subscribe() (history []Event, ch <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := make(chan Event, 256)
+	history = append([]Event(nil), b.history...)
+	if b.closed {
+		close(c)
+		return history, c, func() {}
+	}
+	b.subs[c] = struct{}{}
+	return history, c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[c]; ok {
+			delete(b.subs, c)
+			close(c)
+		}
+	}
+}
+
+// Close ends the stream for all subscribers.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// ServeHTTP streams the events as Server-Sent Events until the broker is closed or the client goes away.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	history, ch, cancel := b.Subscribe()
+	defer cancel()
+	for _, e := range history {
+		writeSSE(w, e)
+	}
+	flusher.Flush()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				fmt.Fprint(w, "event: done\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			writeSSE(w, e)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+}
+
+// LineWriter is an io.Writer that splits what is written to it into lines, calling fn for each full line.
+// A line ends with \n, \r\n or a lone \r, so that progress redrawn with \r, like the one of curl or apt, is
+// sent as it goes rather than as one line at the end.
+type LineWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	cr  bool // the last line ended with \r, a \n right after it ends the same line
+	fn  func(line string)
+}
+
+// NewLineWriter creates a LineWriter calling fn for each line written.
+func NewLineWriter(fn func(line string)) *LineWriter {
+	return &LineWriter{fn: fn}
+}
+
+func (l *LineWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Write(p)
+	for {
+		b := l.buf.Bytes()
+		if l.cr && len(b) > 0 {
+			if b[0] == '\n' {
+				l.buf.Next(1)
+			}
+			l.cr = false
+			continue
+		}
+		i := bytes.IndexAny(b, "\r\n")
+		if i < 0 {
+			break
+		}
+		l.cr = b[i] == '\r'
+		l.fn(string(l.buf.Next(i + 1)[:i]))
+	}
+	return len(p), nil
+}
+
+// Flush sends any remaining partial line.
+func (l *LineWriter) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buf.Len() > 0 {
+		l.fn(l.buf.String())
+		l.buf.Reset()
+	}
+}
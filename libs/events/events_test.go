@@ -0,0 +1,183 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+	b.Publish(Event{Type: TypeLine, Index: 0, Step: "build", Line: "before"})
+	history, ch, cancel := b.Subscribe()
+	defer cancel()
+	if len(history) != 1 || history[0].Line != "before" || history[0].Time.IsZero() {
+		t.Fatalf("got history %+v", history)
+	}
+	b.Publish(Event{Type: TypeLine, Index: 1, Step: "test", Line: "after"})
+	select {
+	case e := <-ch:
+		if e.Line != "after" || e.Index != 1 || e.Step != "test" {
+			t.Errorf("got %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the subscriber got no event")
+	}
+	b.Close()
+	if _, ok := <-ch; ok {
+		t.Error("the channel is still open after Close")
+	}
+	// published after Close is dropped, and a late subscriber still gets the history
+	b.Publish(Event{Type: TypeLine, Line: "closed"})
+	history, ch, _ = b.Subscribe()
+	if len(history) != 2 {
+		t.Errorf("got history %+v", history)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("subscribed to a closed broker")
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	_, slow, cancel := b.Subscribe()
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		// more than the buffer of the subscriber, which never reads
+		for i := 0; i < 1000; i++ {
+			b.Publish(Event{Type: TypeLine, Index: i})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a slow subscriber blocked Publish")
+	}
+	if len(slow) != cap(slow) {
+		t.Errorf("got %d buffered events", len(slow))
+	}
+	if e := <-slow; e.Index != 0 {
+		t.Errorf("got the event %d first", e.Index)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	b := NewBroker()
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	b.Publish(Event{Type: TypeStep, Index: 0, Step: "build", Status: "running"})
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got content type %s", ct)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	next := func() (string, Event) {
+		t.Helper()
+		var name string
+		var e Event
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+					t.Fatal(err)
+				}
+			case line == "":
+				return name, e
+			}
+		}
+		t.Fatalf("the stream ended: %v", lines.Err())
+		return "", e
+	}
+	if name, e := next(); name != TypeStep || e.Step != "build" || e.Status != "running" {
+		t.Errorf("got %s %+v", name, e)
+	}
+	b.Publish(Event{Type: TypeLine, Index: 0, Step: "build", Stream: "stdout", Line: "compiling"})
+	if name, e := next(); name != TypeLine || e.Line != "compiling" || e.Stream != "stdout" {
+		t.Errorf("got %s %+v", name, e)
+	}
+
+	// the subscriber is removed once the client goes away
+	disconnect()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		subs := len(b.subs)
+		b.mu.Unlock()
+		if subs == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the subscriber wasn't removed after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeHTTPDone(t *testing.T) {
+	b := NewBroker()
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	b.Publish(Event{Type: TypeLine, Line: "only"})
+	b.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body strings.Builder
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		body.WriteString(lines.Text() + "\n")
+	}
+	if !strings.Contains(body.String(), `"line":"only"`) || !strings.HasSuffix(body.String(), "event: done\ndata: {}\n\n") {
+		t.Errorf("got %q", body.String())
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := NewLineWriter(func(line string) { lines = append(lines, line) })
+	for _, p := range []string{"com", "piling\nlin", "king\r\n", "\n", "done"} {
+		if n, err := w.Write([]byte(p)); n != len(p) || err != nil {
+			t.Fatalf("wrote %d of %q: %v", n, p, err)
+		}
+	}
+	if want := []string{"compiling", "linking", ""}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+	w.Flush()
+	w.Flush()
+	if want := []string{"compiling", "linking", "", "done"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %q after Flush, want %q", lines, want)
+	}
+}
+
+func TestLineWriterProgress(t *testing.T) {
+	var lines []string
+	w := NewLineWriter(func(line string) { lines = append(lines, line) })
+	// the \r\n of a line is split over two writes
+	for _, p := range []string{" 10%\r 55%", "\r100%\r", "\ndone\r\n"} {
+		w.Write([]byte(p))
+	}
+	if want := []string{" 10%", " 55%", "100%", "done"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
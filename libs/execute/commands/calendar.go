@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// calendarShorthands are the calendar events systemd accepts by name.
+var calendarShorthands = []string{"minutely", "hourly", "daily", "weekly", "monthly", "yearly", "annually", "quarterly", "semiannually"}
+
+var weekdays = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+
+var timezone = regexp.MustCompile(`^(UTC|[A-Z][A-Za-z_+-]*(/[A-Za-z0-9_+-]+)+)$`)
+
+// ValidateCalendar checks a calendar event of a timer, like an OnCalendar=, see systemd.time(7):
+//
+//	[weekdays] [[year-]month-day] [hour:minute[:second]] [timezone]
+//
+// like daily, Mon..Fri 02:00, *-*-01 00:00:00 or *:0/15. Each component is a * or a comma separated list of
+// values and ranges like 1..7, with an optional /repetition.
+func ValidateCalendar(spec string) error {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return fmt.Errorf("the calendar event is empty")
+	}
+	if len(fields) == 1 && strings.HasPrefix(fields[0], "@") {
+		if _, err := strconv.ParseUint(fields[0][1:], 10, 64); err != nil {
+			return fmt.Errorf("invalid calendar event %q: expected @ and seconds since the epoch", spec)
+		}
+		return nil
+	}
+	// a trailing timezone, UTC or like Europe/Berlin
+	if len(fields) > 1 && timezone.MatchString(fields[len(fields)-1]) {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) == 1 && contains(calendarShorthands, strings.ToLower(fields[0])) {
+		return nil
+	}
+	if err := validateCalendarFields(fields); err != nil {
+		return fmt.Errorf("invalid calendar event %q: %v", spec, err)
+	}
+	return nil
+}
+
+func validateCalendarFields(fields []string) error {
+	if first := fields[0][0]; first >= 'A' && first <= 'Z' || first >= 'a' && first <= 'z' {
+		if err := validateWeekdays(fields[0]); err != nil {
+			return err
+		}
+		fields = fields[1:]
+	}
+	var date, clock string
+	for _, field := range fields {
+		switch {
+		case strings.Contains(field, ":"):
+			if clock != "" {
+				return fmt.Errorf("more than one time")
+			}
+			clock = field
+		case strings.ContainsAny(field, "-~"):
+			if date != "" || clock != "" {
+				return fmt.Errorf("unexpected %q", field)
+			}
+			date = field
+		default:
+			return fmt.Errorf("unexpected %q, expected a date like *-*-01 or a time like 02:00", field)
+		}
+	}
+	if date != "" {
+		if err := validateDate(date); err != nil {
+			return err
+		}
+	}
+	if clock != "" {
+		if err := validateTime(clock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateWeekdays checks a list of weekdays and ranges of them, like Mon,Wed or Mon..Fri, also Mon-Fri.
+func validateWeekdays(field string) error {
+	for _, item := range strings.Split(field, ",") {
+		days := strings.Split(strings.Replace(item, "-", "..", 1), "..")
+		if len(days) > 2 {
+			return fmt.Errorf("invalid weekdays %q", item)
+		}
+		for _, day := range days {
+			if !contains(weekdays, strings.ToLower(day)) {
+				return fmt.Errorf("invalid weekday %q", day)
+			}
+		}
+	}
+	return nil
+}
+
+func validateDate(date string) error {
+	// ~ counts the day back from the end of the month
+	sep := strings.LastIndexAny(date, "-~")
+	parts := strings.Split(date[:sep], "-")
+	parts = append(parts, date[sep+1:])
+	switch len(parts) {
+	case 2:
+		parts = append([]string{"*"}, parts...)
+	case 3:
+	default:
+		return fmt.Errorf("invalid date %q, expected year-month-day or month-day", date)
+	}
+	limits := []struct {
+		name     string
+		min, max int
+	}{{"year", 0, 9999}, {"month", 1, 12}, {"day", 1, 31}}
+	for i, part := range parts {
+		if err := validateComponent(part, limits[i].name, limits[i].min, limits[i].max, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTime(clock string) error {
+	parts := strings.Split(clock, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid time %q, expected hour:minute or hour:minute:second", clock)
+	}
+	if err := validateComponent(parts[0], "hour", 0, 23, false); err != nil {
+		return err
+	}
+	if err := validateComponent(parts[1], "minute", 0, 59, false); err != nil {
+		return err
+	}
+	if len(parts) == 3 {
+		return validateComponent(parts[2], "second", 0, 59, true)
+	}
+	return nil
+}
+
+// validateComponent checks a * or a list of values and ranges of a date or a time, each with an optional /repetition.
+func validateComponent(component, name string, min, max int, fraction bool) error {
+	for _, item := range strings.Split(component, ",") {
+		value, repeat, hasRepeat := strings.Cut(item, "/")
+		if hasRepeat {
+			if n, err := strconv.Atoi(repeat); err != nil || n <= 0 {
+				return fmt.Errorf("invalid repetition %q of the %s", repeat, name)
+			}
+		}
+		if value == "*" {
+			continue
+		}
+		from, to, isRange := strings.Cut(value, "..")
+		values := []string{from}
+		if isRange {
+			values = append(values, to)
+		}
+		for _, v := range values {
+			if fraction {
+				v, _, _ = strings.Cut(v, ".")
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < min || n > max {
+				return fmt.Errorf("invalid %s %q, expected * or %d to %d", name, value, min, max)
+			}
+		}
+	}
+	return nil
+}
+
+var timeSpanPart = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*([A-Za-z]*)`)
+
+var timeSpanUnits = []string{"", "us", "usec", "ms", "msec", "s", "sec", "second", "seconds", "m", "min", "minute", "minutes",
+	"h", "hr", "hour", "hours", "d", "day", "days", "w", "week", "weeks", "M", "month", "months", "y", "year", "years"}
+
+// ValidateTimeSpan checks a time span of a timer, like an OnBootSec= of 5min or 1h 30min, see systemd.time(7).
+func ValidateTimeSpan(span string) error {
+	// the parts don't need a space between them, like 1h30min
+	parts := timeSpanPart.FindAllStringSubmatch(span, -1)
+	invalid := len(parts) == 0 || strings.TrimSpace(timeSpanPart.ReplaceAllString(span, "")) != ""
+	for _, part := range parts {
+		invalid = invalid || !contains(timeSpanUnits, part[2])
+	}
+	if invalid {
+		return fmt.Errorf("invalid time span %q, expected like 30s, 5min or 1h 30min", span)
+	}
+	return nil
+}
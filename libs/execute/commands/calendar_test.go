@@ -0,0 +1,33 @@
+package commands
+
+import "testing"
+
+func TestValidateCalendar(t *testing.T) {
+	valid := []string{"daily", "hourly Europe/Berlin", "daily UTC", "*:0/15", "*-02~03", "Mon,Sun 12-*-* 2,1:23",
+		"Mon..Fri 02:00", "Mon-Fri 02:00", "Monday 10:00", "Sat,Sun *-*-* 03:30", "*-*-01 00:00:00", "*-*-* 10:00:00.5",
+		"03:30 UTC", "*-*-1/2", "@1700000000", "Fri"}
+	for _, spec := range valid {
+		if err := ValidateCalendar(spec); err != nil {
+			t.Errorf("%q: %v", spec, err)
+		}
+	}
+	invalid := []string{"", "bad", "Fri 25:00", "2024-13-01", "10:00 daily", "12:60", "*:0/0", "Funday 10:00", "@soon"}
+	for _, spec := range invalid {
+		if err := ValidateCalendar(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestValidateTimeSpan(t *testing.T) {
+	for _, span := range []string{"30", "30s", "5min", "1h 30min", "1h30min", "2 weeks", "0.5s"} {
+		if err := ValidateTimeSpan(span); err != nil {
+			t.Errorf("%q: %v", span, err)
+		}
+	}
+	for _, span := range []string{"", "soon", "5 parsecs", "-1s", "1h and 5min"} {
+		if err := ValidateTimeSpan(span); err == nil {
+			t.Errorf("%q: expected an error", span)
+		}
+	}
+}
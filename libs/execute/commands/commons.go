@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"github.com/NubeIO/bios-cli/libs/execute"
 	"regexp"
 	"strings"
@@ -16,13 +17,20 @@ type CommandBody struct {
 }
 
 type Commands interface {
+	// WithContext returns a copy that stops the commands it runs once ctx is done
+	WithContext(ctx context.Context) Commands
 	Run(body *CommandBody) *execute.Response
 	Uptime(timeout ...int) (*UptimeInfo, error)
 	SystemdStatus(unit string) (*StatusResp, error)
-	// SystemdCommand start, stop, restart, enable, disable
+	// SystemdCommand start, stop, restart, enable, disable, mask, unmask, reset-failed
 	SystemdCommand(unit, commandType string) error
+	SystemdDaemonReload() error
 	SystemdShow(unit, property string) (string, error)
 	SystemdIsEnabled(unit string) (bool, error)
+	// SystemdIsActive the active state of the unit, without the details of SystemdStatus
+	SystemdIsActive(unit string) (*StatusResp, error)
+	// SystemdTimers the timers with their next and last trigger times
+	SystemdTimers(units ...string) ([]TimerInfo, error)
 }
 
 type commands struct {
@@ -41,6 +49,12 @@ type UptimeInfo struct {
 	LoadAverages [3]string
 }
 
+func (cmd *commands) WithContext(ctx context.Context) Commands {
+	return &commands{
+		ex: cmd.ex.WithContext(ctx),
+	}
+}
+
 func (cmd *commands) Run(body *CommandBody) *execute.Response {
 	if body == nil {
 		return &execute.Response{
@@ -11,7 +11,10 @@ import (
 	"time"
 )
 
-// SystemdCommand start, stop, restart, enable, disable
+// SystemdActions are the actions of SystemdCommand.
+var SystemdActions = []string{"start", "stop", "restart", "enable", "disable", "mask", "unmask", "reset-failed"}
+
+// SystemdCommand start, stop, restart, enable, disable, mask, unmask, reset-failed
 func (cmd *commands) SystemdCommand(unit, commandType string) error {
 	err := isValidAction(commandType)
 	if err != nil {
@@ -25,13 +28,21 @@ func (cmd *commands) SystemdCommand(unit, commandType string) error {
 }
 
 func isValidAction(action string) error {
-	validActions := []string{"start", "stop", "restart", "enable", "disable"}
-	for _, valid := range validActions {
+	for _, valid := range SystemdActions {
 		if action == valid {
 			return nil // Match found, no error
 		}
 	}
-	return fmt.Errorf("invalid action: %s, try: %s or %s", action, validActions[0], validActions[1])
+	return fmt.Errorf("invalid action: %s, try: %s", action, strings.Join(SystemdActions, ", "))
+}
+
+// SystemdDaemonReload reloads the unit files, after they were added or changed
+func (cmd *commands) SystemdDaemonReload() error {
+	c := cmd.ex.Run("systemctl", "daemon-reload")
+	if c.AsError() != nil {
+		return c.AsError()
+	}
+	return nil
 }
 
 func (cmd *commands) SystemdShow(unit, property string) (string, error) {
@@ -44,13 +55,15 @@ func (cmd *commands) SystemdShow(unit, property string) (string, error) {
 
 func (cmd *commands) SystemdIsEnabled(unit string) (bool, error) {
 	c := cmd.ex.Run("systemctl", "is-enabled", unit)
-	if c.AsError() != nil {
-		return false, c.AsError()
-	}
 
 	// Trim and convert the output to lowercase
 	output := strings.ToLower(strings.TrimSpace(c.AsString()))
 
+	// is-enabled exits non-zero for a unit that isn't enabled, with its state, like disabled or masked
+	if c.AsError() != nil && output == "" {
+		return false, c.AsError()
+	}
+
 	// Check if the output is "enabled"
 	if output == "enabled" {
 		return true, nil
@@ -59,16 +72,28 @@ func (cmd *commands) SystemdIsEnabled(unit string) (bool, error) {
 	return false, nil
 }
 
+func (cmd *commands) SystemdIsActive(unit string) (*StatusResp, error) {
+	c := cmd.ex.Run("systemctl", "is-active", unit)
+	// is-active exits non-zero for a unit that isn't active, with its state, like inactive or failed
+	state := strings.TrimSpace(c.AsString())
+	if c.AsError() != nil && state == "" {
+		return nil, c.AsError()
+	}
+	return &StatusResp{Status: state, IsActive: state == "active", IsFailed: state == "failed"}, nil
+}
+
 func (cmd *commands) SystemdStatus(unit string) (*StatusResp, error) {
 	c := cmd.ex.Run("systemctl", "status", unit)
-	if c.AsError() != nil {
+	// status exits 3 for a unit that isn't running, and 4 for one that doesn't exist
+	if c.AsError() != nil && (c.ExitCode() != 3 || c.AsString() == "") {
 		return nil, c.AsError()
 	}
 	out := parseSystemdStatusOutput(c.AsString())
-	s, err := cmd.SystemdShow(unit, "NRestarts")
-	count, err := parseRestartCount(s)
-	if err == nil {
-		out.RestartCount = count
+	// the restart count and the enabled state are left out when systemctl can't tell them
+	if s, err := cmd.SystemdShow(unit, "NRestarts"); err == nil {
+		if count, err := parseRestartCount(s); err == nil {
+			out.RestartCount = count
+		}
 	}
 	enabled, err := cmd.SystemdIsEnabled(unit)
 	if err == nil {
@@ -106,6 +131,7 @@ func parseSystemdStatusOutput(output string) *StatusResp {
 
 	// Use regular expressions to extract relevant information
 	reStatus := regexp.MustCompile(`Active: (.+) \((.+)\) since (.+);`)
+	reState := regexp.MustCompile(`Active: (\S+)`)
 	rePID := regexp.MustCompile(`Main PID: (\d+)`)
 	reMemory := regexp.MustCompile(`Memory: (.+)`)
 	reCPU := regexp.MustCompile(`CPU: (.+)`)
@@ -127,6 +153,10 @@ func parseSystemdStatusOutput(output string) *StatusResp {
 		}
 		statusInfo.RunningSince, _ = time.Parse("Mon 2006-01-02 15:04:05 MST", matchesStatus[3])
 		statusInfo.Uptime = times.New(statusInfo.RunningSince).TimeSince()
+	} else if matches := reState.FindStringSubmatch(output); len(matches) >= 2 {
+		// like Active: inactive (dead), without a since
+		statusInfo.Status = matches[1]
+		statusInfo.IsFailed = statusInfo.Status == "failed"
 	}
 	if len(matchesPID) >= 2 {
 		statusInfo.PID, _ = strconv.Atoi(matchesPID[1])
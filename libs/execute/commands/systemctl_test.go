@@ -0,0 +1,159 @@
+package commands
+
+import (
+	"context"
+	"github.com/NubeIO/bios-cli/libs/execute"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cannedExecute runs a shell script instead of each command, by the command line, so that the commands can be
+// tested with the output and exit codes of systemctl. A command without a script fails with exit status 99.
+type cannedExecute struct {
+	scripts map[string]string
+	ran     []string
+}
+
+func (e *cannedExecute) AddTimeout(int) execute.Execute { return e }
+
+func (e *cannedExecute) WithContext(context.Context) execute.Execute { return e }
+
+func (e *cannedExecute) Run(name string, args ...string) *execute.Response {
+	line := strings.Join(append([]string{name}, args...), " ")
+	e.ran = append(e.ran, line)
+	script, ok := e.scripts[line]
+	if !ok {
+		script = "echo unexpected command >&2; exit 99"
+	}
+	return execute.New().Run("sh", "-c", script)
+}
+
+func TestSystemdExitCodes(t *testing.T) {
+	status := `printf '%s\n' '* driver.service - the driver' '     Loaded: loaded (/etc/systemd/system/driver.service; disabled)' '     Active: inactive (dead)'`
+	tests := []struct {
+		name    string
+		scripts map[string]string
+		run     func(c Commands) (interface{}, error)
+		want    interface{}
+		err     string
+	}{
+		{
+			name:    "is-enabled of an enabled unit",
+			scripts: map[string]string{"systemctl is-enabled driver": "echo enabled"},
+			run:     func(c Commands) (interface{}, error) { return c.SystemdIsEnabled("driver") },
+			want:    true,
+		},
+		{
+			name:    "is-enabled of a disabled unit",
+			scripts: map[string]string{"systemctl is-enabled driver": "echo disabled; exit 1"},
+			run:     func(c Commands) (interface{}, error) { return c.SystemdIsEnabled("driver") },
+			want:    false,
+		},
+		{
+			name:    "is-enabled of a masked unit",
+			scripts: map[string]string{"systemctl is-enabled driver": "echo masked; exit 1"},
+			run:     func(c Commands) (interface{}, error) { return c.SystemdIsEnabled("driver") },
+			want:    false,
+		},
+		{
+			name:    "is-enabled of a static unit",
+			scripts: map[string]string{"systemctl is-enabled driver": "echo static"},
+			run:     func(c Commands) (interface{}, error) { return c.SystemdIsEnabled("driver") },
+			want:    false,
+		},
+		{
+			name:    "is-enabled of a missing unit",
+			scripts: map[string]string{"systemctl is-enabled driver": "echo 'Failed to get unit file state for driver.service: No such file or directory' >&2; exit 1"},
+			run:     func(c Commands) (interface{}, error) { return c.SystemdIsEnabled("driver") },
+			err:     "exit status 1: Failed to get unit file state for driver.service: No such file or directory",
+		},
+		{
+			name:    "is-active of an inactive unit",
+			scripts: map[string]string{"systemctl is-active driver": "echo inactive; exit 3"},
+			run:     func(c Commands) (interface{}, error) { return c.SystemdIsActive("driver") },
+			want:    &StatusResp{Status: "inactive"},
+		},
+		{
+			name:    "is-active of a failed unit",
+			scripts: map[string]string{"systemctl is-active driver": "echo failed; exit 3"},
+			run:     func(c Commands) (interface{}, error) { return c.SystemdIsActive("driver") },
+			want:    &StatusResp{Status: "failed", IsFailed: true},
+		},
+		{
+			name: "status of a stopped unit",
+			scripts: map[string]string{
+				"systemctl status driver":            status + "; exit 3",
+				"systemctl show -p NRestarts driver": "echo NRestarts=2",
+				"systemctl is-enabled driver":        "echo disabled; exit 1",
+			},
+			run:  func(c Commands) (interface{}, error) { return c.SystemdStatus("driver") },
+			want: &StatusResp{Status: "inactive", RestartCount: 2},
+		},
+		{
+			name: "status without the restart count",
+			scripts: map[string]string{
+				"systemctl status driver":            status + "; exit 3",
+				"systemctl show -p NRestarts driver": "echo 'Failed to connect to bus' >&2; exit 1",
+				"systemctl is-enabled driver":        "echo enabled",
+			},
+			run:  func(c Commands) (interface{}, error) { return c.SystemdStatus("driver") },
+			want: &StatusResp{Status: "inactive", IsEnabled: true},
+		},
+		{
+			name:    "status of a missing unit",
+			scripts: map[string]string{"systemctl status driver": "echo 'Unit driver.service could not be found.' >&2; exit 4"},
+			run:     func(c Commands) (interface{}, error) { return c.SystemdStatus("driver") },
+			err:     "exit status 4: Unit driver.service could not be found.",
+		},
+		{
+			name:    "failed action",
+			scripts: map[string]string{"systemctl restart driver": "echo 'Job for driver.service failed.' >&2; exit 1"},
+			run:     func(c Commands) (interface{}, error) { return nil, c.SystemdCommand("driver", "restart") },
+			err:     "exit status 1: Job for driver.service failed.",
+		},
+		{
+			name:    "action",
+			scripts: map[string]string{"systemctl mask driver": "echo 'Created symlink /etc/systemd/system/driver.service → /dev/null.' >&2"},
+			run:     func(c Commands) (interface{}, error) { return nil, c.SystemdCommand("driver", "mask") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.run(&commands{ex: &cannedExecute{scripts: tt.scripts}})
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSystemdStatusOutput(t *testing.T) {
+	out := parseSystemdStatusOutput(`* driver.service - the driver
+     Loaded: loaded (/etc/systemd/system/driver.service; enabled; preset: enabled)
+     Active: active (running) since Mon 2024-01-01 00:00:00 UTC; 1h ago
+   Main PID: 1660 (app)
+     Memory: 12.5M
+        CPU: 1.2s`)
+	if out.Status != "active" || !out.IsActive || out.PID != 1660 || out.Memory != "12.5M" || out.CPU != "1.2s" || out.RunningSince.IsZero() {
+		t.Errorf("got %+v", out)
+	}
+	for output, status := range map[string]string{
+		"     Active: inactive (dead)": "inactive",
+		"     Active: failed (Result: exit-code) since Mon 2024-01-01 00:00:00 UTC; 1h ago": "failed",
+	} {
+		out := parseSystemdStatusOutput(output)
+		if out.Status != status || out.IsActive || out.IsFailed != (status == "failed") {
+			t.Errorf("%q: got %+v", output, out)
+		}
+	}
+}
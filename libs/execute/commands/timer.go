@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimerInfo is a systemd timer with the times it triggers at.
+type TimerInfo struct {
+	Unit      string     `json:"unit"`
+	Activates string     `json:"activates"`      // the unit the timer starts
+	Next      *time.Time `json:"next,omitempty"` // nil when the timer isn't scheduled
+	Last      *time.Time `json:"last,omitempty"` // nil when the timer never triggered
+}
+
+// SystemdTimers lists the timers with their next and last trigger times, all of them when no units are given.
+// It asks systemctl list-timers for JSON, which needs systemd 250 or newer, and falls back to systemctl show.
+func (cmd *commands) SystemdTimers(units ...string) ([]TimerInfo, error) {
+	c := cmd.ex.Run("systemctl", append([]string{"list-timers", "--all", "--output=json", "--no-pager"}, units...)...)
+	if c.AsError() == nil {
+		if timers, err := parseListTimers(c.AsString()); err == nil {
+			return timers, nil
+		}
+	}
+	if len(units) == 0 {
+		c = cmd.ex.Run("systemctl", "list-units", "--type=timer", "--all", "--plain", "--no-legend", "--no-pager")
+		if c.AsError() != nil {
+			return nil, c.AsError()
+		}
+		for _, line := range strings.Split(c.AsString(), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				units = append(units, fields[0])
+			}
+		}
+		if len(units) == 0 {
+			return []TimerInfo{}, nil
+		}
+	}
+	c = cmd.ex.Run("systemctl", append([]string{"show", "-p", "Id", "-p", "Triggers", "-p", "NextElapseUSecRealtime", "-p", "LastTriggerUSec", "--"}, units...)...)
+	if c.AsError() != nil {
+		return nil, c.AsError()
+	}
+	return parseShowTimers(c.AsString()), nil
+}
+
+// parseListTimers parses the output of systemctl list-timers --output=json, its times are in microseconds
+// since the epoch.
+func parseListTimers(output string) ([]TimerInfo, error) {
+	var rows []struct {
+		Unit      string `json:"unit"`
+		Activates string `json:"activates"`
+		Next      *int64 `json:"next"`
+		Last      *int64 `json:"last"`
+	}
+	if err := json.Unmarshal([]byte(output), &rows); err != nil {
+		return nil, fmt.Errorf("failed to parse the timers: %v", err)
+	}
+	usec := func(v *int64) *time.Time {
+		if v == nil || *v <= 0 {
+			return nil
+		}
+		t := time.UnixMicro(*v)
+		return &t
+	}
+	timers := make([]TimerInfo, 0, len(rows))
+	for _, row := range rows {
+		timers = append(timers, TimerInfo{Unit: row.Unit, Activates: row.Activates, Next: usec(row.Next), Last: usec(row.Last)})
+	}
+	return timers, nil
+}
+
+// parseShowTimers parses the output of systemctl show of timers, the properties of each one separated by a
+// blank line, like:
+//
+//	Id=backup.timer
+//	Triggers=backup.service
+//	NextElapseUSecRealtime=Mon 2024-01-01 00:00:00 UTC
+//	LastTriggerUSec=n/a
+func parseShowTimers(output string) []TimerInfo {
+	timers := []TimerInfo{}
+	var timer *TimerInfo
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			timer = nil
+			continue
+		}
+		if timer == nil {
+			timers = append(timers, TimerInfo{})
+			timer = &timers[len(timers)-1]
+		}
+		switch key {
+		case "Id":
+			timer.Unit = value
+		case "Triggers":
+			timer.Activates = value
+		case "NextElapseUSecRealtime":
+			timer.Next = parseSystemdTime(value)
+		case "LastTriggerUSec":
+			timer.Last = parseSystemdTime(value)
+		}
+	}
+	return timers
+}
+
+// parseSystemdTime parses a timestamp the way systemctl shows it, nil when it is empty, n/a or not a timestamp.
+func parseSystemdTime(value string) *time.Time {
+	t, err := time.ParseInLocation("Mon 2006-01-02 15:04:05 MST", value, time.Local)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,140 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseListTimers(t *testing.T) {
+	timers, err := parseListTimers(`[{"next":1704067200000000,"left":1000,"last":null,"passed":null,"unit":"backup.timer","activates":"backup.service"},
+{"next":0,"left":0,"last":1704060000000000,"passed":1000,"unit":"old.timer","activates":"old.service"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timers) != 2 {
+		t.Fatalf("got %d timers", len(timers))
+	}
+	if timer := timers[0]; timer.Unit != "backup.timer" || timer.Activates != "backup.service" || timer.Last != nil ||
+		timer.Next == nil || !timer.Next.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v", timer)
+	}
+	if timer := timers[1]; timer.Next != nil || timer.Last == nil {
+		t.Errorf("got %+v", timer)
+	}
+	if _, err := parseListTimers("NEXT LEFT LAST PASSED UNIT ACTIVATES"); err == nil {
+		t.Error("expected an error for the table output")
+	}
+}
+
+func TestParseShowTimers(t *testing.T) {
+	timers := parseShowTimers(`Id=backup.timer
+Triggers=backup.service
+NextElapseUSecRealtime=Mon 2024-01-01 00:00:00 UTC
+LastTriggerUSec=n/a
+
+Id=old.timer
+Triggers=old.service
+NextElapseUSecRealtime=
+LastTriggerUSec=Sun 2023-12-31 22:00:00 UTC
+`)
+	if len(timers) != 2 {
+		t.Fatalf("got %d timers", len(timers))
+	}
+	if timer := timers[0]; timer.Unit != "backup.timer" || timer.Activates != "backup.service" || timer.Last != nil ||
+		timer.Next == nil || timer.Next.Unix() != time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix() {
+		t.Errorf("got %+v", timer)
+	}
+	if timer := timers[1]; timer.Unit != "old.timer" || timer.Next != nil || timer.Last == nil {
+		t.Errorf("got %+v", timer)
+	}
+}
+
+func TestSystemdTimers(t *testing.T) {
+	listTimers := "systemctl list-timers --all --output=json --no-pager"
+	listUnits := "systemctl list-units --type=timer --all --plain --no-legend --no-pager"
+	show := "systemctl show -p Id -p Triggers -p NextElapseUSecRealtime -p LastTriggerUSec --"
+	showOutput := `printf '%s\n' Id=backup.timer Triggers=backup.service 'NextElapseUSecRealtime=Mon 2024-01-01 00:00:00 UTC' LastTriggerUSec=n/a`
+	tests := []struct {
+		name    string
+		units   []string
+		scripts map[string]string
+		ran     []string
+		want    []string
+		err     string
+	}{
+		{
+			name:    "list-timers JSON",
+			scripts: map[string]string{listTimers: `echo '[{"next":1704067200000000,"unit":"backup.timer","activates":"backup.service"}]'`},
+			ran:     []string{listTimers},
+			want:    []string{"backup.timer"},
+		},
+		{
+			name:  "show when the output option isn't supported",
+			units: []string{"backup.timer"},
+			scripts: map[string]string{
+				listTimers + " backup.timer": "echo \"systemctl: unrecognized option '--output=json'\" >&2; exit 1",
+				show + " backup.timer":       showOutput,
+			},
+			ran:  []string{listTimers + " backup.timer", show + " backup.timer"},
+			want: []string{"backup.timer"},
+		},
+		{
+			name: "show when the output is a table",
+			scripts: map[string]string{
+				listTimers:                        "echo 'NEXT LEFT LAST PASSED UNIT ACTIVATES'",
+				listUnits:                         "echo 'backup.timer loaded active waiting the backup'; echo 'logs.timer loaded active waiting the logs'",
+				show + " backup.timer logs.timer": showOutput + "; echo; printf '%s\\n' Id=logs.timer Triggers=logs.service NextElapseUSecRealtime= LastTriggerUSec=",
+			},
+			ran:  []string{listTimers, listUnits, show + " backup.timer logs.timer"},
+			want: []string{"backup.timer", "logs.timer"},
+		},
+		{
+			name: "no timers",
+			scripts: map[string]string{
+				listTimers: "exit 1",
+				listUnits:  "true",
+			},
+			ran:  []string{listTimers, listUnits},
+			want: []string{},
+		},
+		{
+			name: "show fails",
+			scripts: map[string]string{
+				listTimers:             "exit 1",
+				listUnits:              "echo backup.timer loaded active waiting",
+				show + " backup.timer": "echo 'Access denied' >&2; exit 1",
+			},
+			ran: []string{listTimers, listUnits, show + " backup.timer"},
+			err: "exit status 1: Access denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := &cannedExecute{scripts: tt.scripts}
+			timers, err := (&commands{ex: ex}).SystemdTimers(tt.units...)
+			if !reflect.DeepEqual(ex.ran, tt.ran) {
+				t.Errorf("ran %q, want %q", ex.ran, tt.ran)
+			}
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, timer := range timers {
+				got = append(got, timer.Unit)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got timers %v, want %v", got, tt.want)
+			}
+			if len(timers) > 0 && (timers[0].Next == nil || timers[0].Activates != "backup.service") {
+				t.Errorf("got %+v", timers[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Kinds of the lines of a unit file.
+const (
+	LineBlank     = "blank"
+	LineComment   = "comment"
+	LineSection   = "section"
+	LineDirective = "directive"
+)
+
+// UnitLine is a line of a unit file. A directive continued over several lines with a trailing backslash is
+// a single UnitLine.
+type UnitLine struct {
+	Kind    string
+	Section string // the section the line is in, or the section it starts
+	Key     string // of a directive
+	Value   string // of a directive, with its continuation lines joined by a space
+	Raw     string // the line as it was read, it is written back as it is unless the directive was changed
+}
+
+// Directive is a Key=Value of a section.
+type Directive struct {
+	Key   string
+	Value string
+}
+
+// UnitFile is a systemd unit file kept as its lines, so that it is written back as it was read, with its
+// comments, blank lines, ordering and repeated keys. Only the directives that are set, added or removed change.
+// A section can appear more than once, like systemd the directives of all of them are the directives of the section.
+type UnitFile struct {
+	Lines []*UnitLine
+}
+
+// ReadUnitFile parses the unit file at path.
+func ReadUnitFile(path string) (*UnitFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	u, err := ParseUnitFile(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return u, nil
+}
+
+// ParseUnitFile parses the content of a unit file.
+func ParseUnitFile(content string) (*UnitFile, error) {
+	u := &UnitFile{}
+	content = strings.TrimSuffix(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	if content == "" {
+		return u, nil
+	}
+	lines := strings.Split(content, "\n")
+	section := ""
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			u.Lines = append(u.Lines, &UnitLine{Kind: LineBlank, Section: section, Raw: line})
+		case strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			u.Lines = append(u.Lines, &UnitLine{Kind: LineComment, Section: section, Raw: line})
+		case strings.HasPrefix(trimmed, "["):
+			if !strings.HasSuffix(trimmed, "]") {
+				return nil, fmt.Errorf("line %d: invalid section %q", i+1, trimmed)
+			}
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			u.Lines = append(u.Lines, &UnitLine{Kind: LineSection, Section: section, Raw: line})
+		default:
+			start := i
+			key, value, ok := strings.Cut(trimmed, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected Key=Value, got %q", i+1, trimmed)
+			}
+			if section == "" {
+				return nil, fmt.Errorf("line %d: %s is not in a section", i+1, strings.TrimSpace(key))
+			}
+			value = strings.TrimSpace(value)
+			// a trailing backslash continues the value on the next line, comments in between are skipped
+			for strings.HasSuffix(value, "\\") && i+1 < len(lines) {
+				i++
+				next := strings.TrimSpace(lines[i])
+				if strings.HasPrefix(next, "#") || strings.HasPrefix(next, ";") {
+					continue
+				}
+				value = strings.TrimSpace(strings.TrimSuffix(value, "\\")) + " " + next
+			}
+			u.Lines = append(u.Lines, &UnitLine{
+				Kind:    LineDirective,
+				Section: section,
+				Key:     strings.TrimSpace(key),
+				Value:   value,
+				Raw:     strings.Join(lines[start:i+1], "\n"),
+			})
+		}
+	}
+	return u, nil
+}
+
+// String writes the unit file.
+func (u *UnitFile) String() string {
+	var sb strings.Builder
+	for _, line := range u.Lines {
+		switch {
+		case line.Raw != "" || line.Kind == LineBlank:
+			sb.WriteString(line.Raw)
+		case line.Kind == LineSection:
+			fmt.Fprintf(&sb, "[%s]", line.Section)
+		default:
+			fmt.Fprintf(&sb, "%s=%s", line.Key, line.Value)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// Sections returns the names of the sections, in order, once each.
+func (u *UnitFile) Sections() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, line := range u.Lines {
+		if line.Kind == LineSection && !seen[line.Section] {
+			seen[line.Section] = true
+			names = append(names, line.Section)
+		}
+	}
+	return names
+}
+
+// Directives returns the directives of the section, in order.
+func (u *UnitFile) Directives(section string) []Directive {
+	var out []Directive
+	for _, line := range u.Lines {
+		if line.Kind == LineDirective && line.Section == section {
+			out = append(out, Directive{Key: line.Key, Value: line.Value})
+		}
+	}
+	return out
+}
+
+// Get returns every value of the key in the section, in order.
+func (u *UnitFile) Get(section, key string) []string {
+	var values []string
+	for _, d := range u.Directives(section) {
+		if d.Key == key {
+			values = append(values, d.Value)
+		}
+	}
+	return values
+}
+
+// Set replaces every value of the key in the section with the values, in the place of the first one, or at the
+// end of the section when it has none. It reports whether the file changed.
+func (u *UnitFile) Set(section, key string, values ...string) bool {
+	if equal(u.Get(section, key), values) {
+		return false
+	}
+	var out []*UnitLine
+	added := false
+	for _, line := range u.Lines {
+		if line.Kind != LineDirective || line.Section != section || line.Key != key {
+			out = append(out, line)
+			continue
+		}
+		if !added {
+			out = append(out, directiveLines(section, key, values)...)
+			added = true
+		}
+	}
+	u.Lines = out
+	if !added {
+		u.insert(section, directiveLines(section, key, values))
+	}
+	return true
+}
+
+// Add appends the directive to the section, unless the section already has it with the same value.
+// It reports whether the file changed.
+func (u *UnitFile) Add(section, key, value string) bool {
+	for _, v := range u.Get(section, key) {
+		if v == value {
+			return false
+		}
+	}
+	u.Append(section, key, value)
+	return true
+}
+
+// Append appends the directive to the section, even when it already has it.
+func (u *UnitFile) Append(section, key, value string) {
+	u.insert(section, directiveLines(section, key, []string{value}))
+}
+
+// Remove removes the key from the section, only the directives with one of the values when values are given.
+// It reports whether the file changed.
+func (u *UnitFile) Remove(section, key string, values ...string) bool {
+	var out []*UnitLine
+	for _, line := range u.Lines {
+		if line.Kind == LineDirective && line.Section == section && line.Key == key && (len(values) == 0 || contains(values, line.Value)) {
+			continue
+		}
+		out = append(out, line)
+	}
+	changed := len(out) != len(u.Lines)
+	u.Lines = out
+	return changed
+}
+
+// insert puts the lines after the last directive of the section, adding the section at the end when the file
+// doesn't have it.
+func (u *UnitFile) insert(section string, lines []*UnitLine) {
+	at := -1
+	for i, line := range u.Lines {
+		if line.Section == section && (line.Kind == LineDirective || line.Kind == LineSection) {
+			at = i
+		}
+	}
+	if at < 0 {
+		if n := len(u.Lines); n > 0 && u.Lines[n-1].Kind != LineBlank {
+			u.Lines = append(u.Lines, &UnitLine{Kind: LineBlank, Section: u.Lines[n-1].Section})
+		}
+		u.Lines = append(u.Lines, &UnitLine{Kind: LineSection, Section: section})
+		u.Lines = append(u.Lines, lines...)
+		return
+	}
+	u.Lines = append(u.Lines[:at+1], append(lines, u.Lines[at+1:]...)...)
+}
+
+// listDirectives are the directives that add to a list when they are repeated, the others replace the value.
+var listDirectives = []string{
+	"Documentation", "After", "Before", "Wants", "Requires", "Requisite", "BindsTo", "PartOf", "Conflicts", "OnFailure",
+	"Environment", "EnvironmentFile", "ExecCondition", "ExecStartPre", "ExecStart", "ExecStartPost", "ExecReload",
+	"ExecStop", "ExecStopPost", "SupplementaryGroups", "ReadWritePaths", "ReadOnlyPaths", "OnCalendar",
+	"WantedBy", "RequiredBy", "Alias", "Also",
+}
+
+// IsListDirective reports whether the directive adds to a list when it is repeated, like Environment or
+// ExecStartPre, so that a drop-in has to reset it with an empty value to replace it.
+func IsListDirective(key string) bool {
+	return contains(listDirectives, key) || strings.HasPrefix(key, "Condition") || strings.HasPrefix(key, "Assert")
+}
+
+// Merge returns the directives of a unit file with its drop-ins applied in order, the way systemd does: an
+// empty value resets the key, a list directive adds to the values it has, and the others replace it.
+func Merge(files ...*UnitFile) *UnitFile {
+	merged := &UnitFile{}
+	for _, file := range files {
+		for _, line := range file.Lines {
+			if line.Kind != LineDirective {
+				continue
+			}
+			switch {
+			case line.Value == "":
+				merged.Remove(line.Section, line.Key)
+			case IsListDirective(line.Key):
+				merged.Append(line.Section, line.Key, line.Value)
+			default:
+				merged.Set(line.Section, line.Key, line.Value)
+			}
+		}
+	}
+	return merged
+}
+
+func directiveLines(section, key string, values []string) []*UnitLine {
+	lines := make([]*UnitLine, 0, len(values))
+	for _, value := range values {
+		lines = append(lines, &UnitLine{Kind: LineDirective, Section: section, Key: key, Value: value})
+	}
+	return lines
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+)
+
+const unit = `# managed by bios
+[Unit]
+Description=the driver
+After=network.target
+
+[Service]
+; the app
+ExecStart=/opt/driver/app \
+  -p 1660
+Environment="PORT=1660"
+Environment="LOG=info"
+Restart=always
+
+[Install]
+WantedBy=multi-user.target
+`
+
+func TestUnitFileRoundTrip(t *testing.T) {
+	u, err := ParseUnitFile(unit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.String(); got != unit {
+		t.Fatalf("the file changed:\n%s", got)
+	}
+	if got := u.Sections(); !reflect.DeepEqual(got, []string{"Unit", "Service", "Install"}) {
+		t.Errorf("got sections %v", got)
+	}
+	if got := u.Get("Service", "ExecStart"); !reflect.DeepEqual(got, []string{"/opt/driver/app -p 1660"}) {
+		t.Errorf("got ExecStart %q", got)
+	}
+	if got := u.Get("Service", "Environment"); len(got) != 2 {
+		t.Errorf("got Environment %q", got)
+	}
+}
+
+func TestUnitFileEdit(t *testing.T) {
+	u, err := ParseUnitFile(unit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Set("Service", "Restart", "always") || u.Add("Service", "Environment", `"PORT=1660"`) || u.Remove("Service", "User") {
+		t.Fatal("expected no change")
+	}
+	if !u.Add("Service", "Environment", `"DEBUG=1"`) {
+		t.Fatal("expected Add to change the file")
+	}
+	if !u.Set("Service", "Restart", "on-failure") {
+		t.Fatal("expected Set to change the file")
+	}
+	if !u.Remove("Unit", "After") {
+		t.Fatal("expected Remove to change the file")
+	}
+	if !u.Set("Timer", "OnCalendar", "daily") {
+		t.Fatal("expected Set to add the section")
+	}
+	want := `# managed by bios
+[Unit]
+Description=the driver
+
+[Service]
+; the app
+ExecStart=/opt/driver/app \
+  -p 1660
+Environment="PORT=1660"
+Environment="LOG=info"
+Restart=on-failure
+Environment="DEBUG=1"
+
+[Install]
+WantedBy=multi-user.target
+
+[Timer]
+OnCalendar=daily
+`
+	if got := u.String(); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseUnitFileErrors(t *testing.T) {
+	for _, content := range []string{"Description=x", "[Unit\nA=b", "[Unit]\nnot a directive"} {
+		if _, err := ParseUnitFile(content); err == nil {
+			t.Errorf("%q: expected an error", content)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base, err := ParseUnitFile(unit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	override, err := ParseUnitFile("[Service]\nExecStart=\nExecStart=/opt/driver/app -p 1770\nEnvironment=\"DEBUG=1\"\nRestart=on-failure\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := Merge(base, override)
+	if got := merged.Get("Service", "ExecStart"); !reflect.DeepEqual(got, []string{"/opt/driver/app -p 1770"}) {
+		t.Errorf("got ExecStart %q", got)
+	}
+	if got := merged.Get("Service", "Environment"); len(got) != 3 {
+		t.Errorf("got Environment %q", got)
+	}
+	if got := merged.Get("Service", "Restart"); !reflect.DeepEqual(got, []string{"on-failure"}) {
+		t.Errorf("got Restart %q", got)
+	}
+	if got := merged.Get("Unit", "Description"); !reflect.DeepEqual(got, []string{"the driver"}) {
+		t.Errorf("got Description %q", got)
+	}
+}
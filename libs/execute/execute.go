@@ -1,6 +1,7 @@
 package execute
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-cmd/cmd"
 	"strings"
@@ -9,12 +10,14 @@ import (
 
 type Execute interface {
 	AddTimeout(timeout int) Execute
+	// WithContext returns a copy that stops the command, and its process group, once ctx is done
+	WithContext(ctx context.Context) Execute
 	Run(name string, args ...string) *Response
 }
 
 type execute struct {
-	cmd     *cmd.Cmd
 	timeout time.Duration
+	ctx     context.Context
 }
 
 func New() Execute {
@@ -26,40 +29,55 @@ func (e *execute) AddTimeout(timeout int) Execute {
 	return e
 }
 
+func (e *execute) WithContext(ctx context.Context) Execute {
+	c := *e
+	c.ctx = ctx
+	return &c
+}
+
 func (e *execute) Run(name string, args ...string) *Response {
-	e.cmd = cmd.NewCmd(name, args...)
 	if name == "" {
 		return &Response{
 			Error: "command name can not be empty, try something like; pwd, uptime",
 		}
 	}
 
-	statusChan := e.cmd.Start() // non-blocking
+	c := cmd.NewCmd(name, args...)
+	statusChan := c.Start() // non-blocking
 
-	// create a timeout
+	// create a timeout, a nil channel never fires
+	var timeout <-chan time.Time
 	if e.timeout > 0 {
-		timeout := time.After(e.timeout)
-		select {
-		case <-statusChan:
-			// command finished
-		case <-timeout:
-			// command timed out
-			e.cmd.Stop() // optional: try to stop the process
-			return &Response{
-				status: cmd.Status{Error: fmt.Errorf("command timed out")},
-			}
+		timeout = time.After(e.timeout)
+	}
+	var done <-chan struct{}
+	if e.ctx != nil {
+		done = e.ctx.Done()
+	}
+	select {
+	case <-statusChan:
+		// command finished
+	case <-timeout:
+		// command timed out
+		c.Stop() // optional: try to stop the process
+		return &Response{
+			status: cmd.Status{Error: fmt.Errorf("command timed out")},
+		}
+	case <-done:
+		c.Stop()
+		return &Response{
+			status: cmd.Status{Error: e.ctx.Err()},
+			Error:  e.ctx.Err().Error(),
 		}
-	} else {
-		<-statusChan // wait for the command to finish
 	}
 
 	// retrieve the status using the function
-	status := e.cmd.Status()
+	status := c.Status()
 	r := &Response{}
 	r.status = status
 	r.Response = r.AsString()
-	if r.status.Error != nil {
-		r.Error = fmt.Sprintf("%v", r.AsError())
+	if err := r.AsError(); err != nil {
+		r.Error = err.Error()
 	}
 	return r
 }
@@ -78,9 +96,23 @@ func (r *Response) GetErrors() []string {
 	return r.status.Stderr
 }
 
+// ExitCode is the exit code of the command, -1 when it was killed.
+func (r *Response) ExitCode() int {
+	return r.status.Exit
+}
+
+// AsError returns the error of the command, when it failed to run or exited non-zero, with its stderr. Some
+// commands exit non-zero to report a state, like systemctl is-enabled for a disabled or masked unit, so their
+// callers look at the output before the error.
 func (r *Response) AsError() error {
 	if r.status.Error != nil {
 		return r.status.Error
 	}
+	if r.status.Exit != 0 {
+		if stderr := strings.TrimSpace(strings.Join(r.status.Stderr, "\n")); stderr != "" {
+			return fmt.Errorf("exit status %d: %s", r.status.Exit, stderr)
+		}
+		return fmt.Errorf("exit status %d", r.status.Exit)
+	}
 	return nil
 }
@@ -1,8 +1,11 @@
 package execute
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -14,3 +17,52 @@ func TestNew(t *testing.T) {
 	fmt.Println(c.AsError())
 	fmt.Println(c.AsString())
 }
+
+func TestAsError(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		exit   int
+		err    string
+		output string
+	}{
+		{name: "success", args: []string{"sh", "-c", "echo out"}, output: "out"},
+		{name: "stderr of a success", args: []string{"sh", "-c", "echo out; echo warning >&2"}, output: "out"},
+		{name: "exit with stderr", args: []string{"sh", "-c", "echo out; echo oops >&2; exit 3"}, exit: 3, err: "exit status 3: oops", output: "out"},
+		{name: "exit without stderr", args: []string{"sh", "-c", "echo masked; exit 1"}, exit: 1, err: "exit status 1", output: "masked"},
+		{name: "multi line stderr", args: []string{"sh", "-c", "echo one >&2; echo two >&2; exit 4"}, exit: 4, err: "exit status 4: one\ntwo"},
+		{name: "not found", args: []string{"bios-test-no-such-command"}, exit: -1, err: `exec: "bios-test-no-such-command": executable file not found in $PATH`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New().Run(tt.args[0], tt.args[1:]...)
+			if c.ExitCode() != tt.exit {
+				t.Errorf("got exit code %d, want %d", c.ExitCode(), tt.exit)
+			}
+			err := c.AsError()
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+			// the error is kept in the response too, for the JSON of a step
+			if tt.err != "" && c.Error != tt.err {
+				t.Errorf("got Error %q", c.Error)
+			}
+			if c.AsString() != tt.output {
+				t.Errorf("got output %q", c.AsString())
+			}
+		})
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	c := New().WithContext(ctx).Run("sleep", "10")
+	if !errors.Is(c.AsError(), context.DeadlineExceeded) {
+		t.Errorf("got error %v", c.AsError())
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("the command wasn't stopped")
+	}
+}
@@ -0,0 +1,379 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Scope holds the values an expression can reference by name.
+type Scope map[string]interface{}
+
+// Func is a function that can be called from an expression.
+type Func func(args ...interface{}) (interface{}, error)
+
+// Node is a parsed expression.
+type Node interface {
+	Eval(env *Env) (interface{}, error)
+}
+
+// Env is what an expression is evaluated against.
+type Env struct {
+	Scope Scope
+	Funcs map[string]Func
+}
+
+// Eval parses and evaluates the expression against the scope.
+//
+// Expressions support string, number, bool and null literals, names and paths into the scope
+// (steps.check.response.isActive, steps["download a build"].status, list[0]), ${name} references,
+// function calls, the comparison operators == != < <= > >=, and !, && and || with parentheses.
+func Eval(src string, scope Scope, funcs map[string]Func) (interface{}, error) {
+	node, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return node.Eval(&Env{Scope: scope, Funcs: funcs})
+}
+
+// EvalBool evaluates the expression and reports whether the result is truthy.
+func EvalBool(src string, scope Scope, funcs map[string]Func) (bool, error) {
+	v, err := Eval(src, scope, funcs)
+	if err != nil {
+		return false, err
+	}
+	return Truthy(v), nil
+}
+
+// Parse parses the expression.
+func Parse(src string) (Node, error) {
+	p := &parser{lex: newLexer(src)}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return node, nil
+}
+
+// Names returns the names at the root of the references in the expression, like steps for steps.download.path,
+// in the order they are used.
+func Names(node Node) []string {
+	var names []string
+	var walk func(n Node)
+	walk = func(n Node) {
+		switch t := n.(type) {
+		case *name:
+			for _, seen := range names {
+				if seen == t.name {
+					return
+				}
+			}
+			names = append(names, t.name)
+		case *field:
+			walk(t.target)
+		case *index:
+			walk(t.target)
+			walk(t.index)
+		case *call:
+			for _, arg := range t.args {
+				walk(arg)
+			}
+		case *not:
+			walk(t.node)
+		case *binary:
+			walk(t.left)
+			walk(t.right)
+		}
+	}
+	walk(node)
+	return names
+}
+
+// Calls returns the names of the functions the expression calls.
+func Calls(node Node) []string {
+	var calls []string
+	var walk func(n Node)
+	walk = func(n Node) {
+		switch t := n.(type) {
+		case *call:
+			calls = append(calls, t.name)
+			for _, arg := range t.args {
+				walk(arg)
+			}
+		case *field:
+			walk(t.target)
+		case *index:
+			walk(t.target)
+			walk(t.index)
+		case *not:
+			walk(t.node)
+		case *binary:
+			walk(t.left)
+			walk(t.right)
+		}
+	}
+	walk(node)
+	return calls
+}
+
+// Truthy reports whether the value counts as true: false, null, "", "false", "0", 0 and empty lists and maps don't.
+func Truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != "" && t != "false" && t != "0"
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	}
+	if f, ok := toNumber(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+// ToString formats a value the way it is written into a string, maps and lists as JSON.
+func ToString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		if b, err := json.Marshal(t); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) Eval(_ *Env) (interface{}, error) {
+	return n.value, nil
+}
+
+type name struct {
+	name string
+}
+
+func (n *name) Eval(env *Env) (interface{}, error) {
+	v, ok := env.Scope[n.name]
+	if !ok {
+		return nil, &UndefinedError{Ref: n.name}
+	}
+	return v, nil
+}
+
+type field struct {
+	target Node
+	name   string
+	path   string
+}
+
+func (n *field) Eval(env *Env) (interface{}, error) {
+	target, err := n.target.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := lookup(target, n.name)
+	if !ok {
+		return nil, &UndefinedError{Ref: n.path}
+	}
+	return v, nil
+}
+
+type index struct {
+	target Node
+	index  Node
+	path   string
+}
+
+func (n *index) Eval(env *Env) (interface{}, error) {
+	target, err := n.target.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.index.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := lookup(target, key)
+	if !ok {
+		return nil, &UndefinedError{Ref: fmt.Sprintf("%s[%s]", n.path, ToString(key))}
+	}
+	return v, nil
+}
+
+// lookup gets a key of a map or an index of a list.
+func lookup(target interface{}, key interface{}) (interface{}, bool) {
+	switch t := target.(type) {
+	case map[string]interface{}:
+		v, ok := t[ToString(key)]
+		return v, ok
+	case map[string]string:
+		v, ok := t[ToString(key)]
+		return v, ok
+	case Scope:
+		v, ok := t[ToString(key)]
+		return v, ok
+	case []interface{}:
+		i, ok := toNumber(key)
+		if !ok || int(i) < 0 || int(i) >= len(t) {
+			return nil, false
+		}
+		return t[int(i)], true
+	}
+	return nil, false
+}
+
+type call struct {
+	name string
+	args []Node
+}
+
+func (n *call) Eval(env *Env) (interface{}, error) {
+	fn, ok := env.Funcs[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undefined function: %s", n.name)
+	}
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.Eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := fn(args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %v", n.name, err)
+	}
+	return v, nil
+}
+
+type not struct {
+	node Node
+}
+
+func (n *not) Eval(env *Env) (interface{}, error) {
+	v, err := n.node.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !Truthy(v), nil
+}
+
+type binary struct {
+	op          string
+	left, right Node
+}
+
+func (n *binary) Eval(env *Env) (interface{}, error) {
+	left, err := n.left.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// && and || short circuit, so the right side may reference something that only exists when it matters
+	switch n.op {
+	case "&&":
+		if !Truthy(left) {
+			return false, nil
+		}
+	case "||":
+		if Truthy(left) {
+			return true, nil
+		}
+	}
+	right, err := n.right.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&", "||":
+		return Truthy(right), nil
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	}
+	c, err := compare(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+// equal compares two values loosely, so the arg "true" equals the bool true and "3" equals 3.
+func equal(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if fa, ok := toNumber(a); ok {
+		if fb, ok := toNumber(b); ok {
+			return fa == fb
+		}
+	}
+	return ToString(a) == ToString(b)
+}
+
+func compare(a, b interface{}) (int, error) {
+	if fa, ok := toNumber(a); ok {
+		if fb, ok := toNumber(b); ok {
+			switch {
+			case fa < fb:
+				return -1, nil
+			case fa > fb:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	sa, aok := a.(string)
+	sb, bok := b.(string)
+	if !aok || !bok {
+		return 0, fmt.Errorf("cannot compare %v and %v", a, b)
+	}
+	return strings.Compare(sa, sb), nil
+}
+
+// toNumber converts numbers, and strings holding a number, to a float64.
+func toNumber(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case float64:
+		return t, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,76 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	scope := Scope{
+		"arch":  "armv7",
+		"debug": "true",
+		"steps": map[string]interface{}{
+			"check": map[string]interface{}{
+				"response": map[string]interface{}{"isActive": false, "pid": float64(12)},
+			},
+			"download a build": map[string]interface{}{"status": "succeeded"},
+		},
+		"list": []interface{}{"a", "b"},
+	}
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`${arch} == "armv7"`, true},
+		{`arch != 'armv7'`, false},
+		{`steps.check.response.isActive == false`, true},
+		{`steps.check.response.pid > 10 && debug == true`, true},
+		{`steps["download a build"].status`, "succeeded"},
+		{`!(list[1] == "b") || missing`, nil},
+		{`list[0] == "a" || missing`, true},
+		{`upper(arch)`, "ARMV7"},
+	}
+	funcs := map[string]Func{
+		"upper": func(args ...interface{}) (interface{}, error) {
+			return "ARMV7", nil
+		},
+	}
+	for _, tt := range tests {
+		got, err := Eval(tt.src, scope, funcs)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tt.src, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	node, err := Parse(`steps.download.path != "" && (${arch} == "arm" || !list[index]) && len(steps)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(Names(node), ",")
+	if want := "steps,arch,list,index"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := strings.Join(Calls(node), ","); got != "len" {
+		t.Errorf("got calls %s, want len", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{`${arch`, `a ==`, `"open`, `(a`, `a b`} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%s: expected a parse error", src)
+		}
+	}
+}
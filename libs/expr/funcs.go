@@ -0,0 +1,148 @@
+package expr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Builtins are the functions every flow expression can call:
+//
+//	upper(s), lower(s), trim(s), replace(s, old, new), base64(s), base64decode(s), join(list, sep), split(s, sep),
+//	json(v), len(v), env(name), default(v, fallback) and now(layout), layout being a Go time layout, RFC3339 by default.
+var Builtins = map[string]Func{
+	"upper": stringFunc(strings.ToUpper),
+	"lower": stringFunc(strings.ToLower),
+	"trim":  stringFunc(strings.TrimSpace),
+	"replace": func(args ...interface{}) (interface{}, error) {
+		if err := argCount(args, 3, 3); err != nil {
+			return nil, err
+		}
+		return strings.ReplaceAll(ToString(args[0]), ToString(args[1]), ToString(args[2])), nil
+	},
+	"base64": stringFunc(func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}),
+	"base64decode": func(args ...interface{}) (interface{}, error) {
+		if err := argCount(args, 1, 1); err != nil {
+			return nil, err
+		}
+		b, err := base64.StdEncoding.DecodeString(ToString(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	},
+	"join": func(args ...interface{}) (interface{}, error) {
+		if err := argCount(args, 1, 2); err != nil {
+			return nil, err
+		}
+		list, ok := args[0].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected a list, not %v", args[0])
+		}
+		sep := ","
+		if len(args) == 2 {
+			sep = ToString(args[1])
+		}
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = ToString(item)
+		}
+		return strings.Join(items, sep), nil
+	},
+	"split": func(args ...interface{}) (interface{}, error) {
+		if err := argCount(args, 1, 2); err != nil {
+			return nil, err
+		}
+		sep := ","
+		if len(args) == 2 {
+			sep = ToString(args[1])
+		}
+		var list []interface{}
+		for _, item := range strings.Split(ToString(args[0]), sep) {
+			list = append(list, item)
+		}
+		return list, nil
+	},
+	"json": func(args ...interface{}) (interface{}, error) {
+		if err := argCount(args, 1, 1); err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	},
+	"len": func(args ...interface{}) (interface{}, error) {
+		if err := argCount(args, 1, 1); err != nil {
+			return nil, err
+		}
+		switch t := args[0].(type) {
+		case []interface{}:
+			return float64(len(t)), nil
+		case map[string]interface{}:
+			return float64(len(t)), nil
+		}
+		return float64(len(ToString(args[0]))), nil
+	},
+	"env": func(args ...interface{}) (interface{}, error) {
+		if err := argCount(args, 1, 1); err != nil {
+			return nil, err
+		}
+		return os.Getenv(ToString(args[0])), nil
+	},
+	"default": func(args ...interface{}) (interface{}, error) {
+		if err := argCount(args, 2, 2); err != nil {
+			return nil, err
+		}
+		if args[0] == nil || args[0] == "" {
+			return args[1], nil
+		}
+		return args[0], nil
+	},
+	"now": func(args ...interface{}) (interface{}, error) {
+		if err := argCount(args, 0, 1); err != nil {
+			return nil, err
+		}
+		layout := time.RFC3339
+		if len(args) == 1 {
+			layout = ToString(args[0])
+		}
+		return time.Now().Format(layout), nil
+	},
+}
+
+// Environ returns the environment variables, for the env of a scope.
+func Environ() map[string]interface{} {
+	env := make(map[string]interface{})
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+func stringFunc(fn func(string) string) Func {
+	return func(args ...interface{}) (interface{}, error) {
+		if err := argCount(args, 1, 1); err != nil {
+			return nil, err
+		}
+		return fn(ToString(args[0])), nil
+	}
+}
+
+func argCount(args []interface{}, min, max int) error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return fmt.Errorf("expected %d args, got %d", min, len(args))
+		}
+		return fmt.Errorf("expected %d to %d args, got %d", min, max, len(args))
+	}
+	return nil
+}
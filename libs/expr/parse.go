@@ -0,0 +1,334 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp      // == != < <= > >= && || !
+	tokPunct   // ( ) [ ] . ,
+	tokRefOpen // ${
+	tokRefClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	src   []rune
+	pos   int
+	depth int // open ${ references, so } is only a token inside one
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: []rune(src)}
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(l.src[l.pos]) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	peek := func(s string) bool {
+		return strings.HasPrefix(string(l.src[l.pos:]), s)
+	}
+	switch {
+	case peek("${"):
+		l.pos += 2
+		l.depth++
+		return token{kind: tokRefOpen, text: "${", pos: start}, nil
+	case c == '}' && l.depth > 0:
+		l.pos++
+		l.depth--
+		return token{kind: tokRefClose, text: "}", pos: start}, nil
+	case peek("==") || peek("!=") || peek("<=") || peek(">=") || peek("&&") || peek("||"):
+		l.pos += 2
+		return token{kind: tokOp, text: string(l.src[start:l.pos]), pos: start}, nil
+	case c == '<' || c == '>' || c == '!':
+		l.pos++
+		return token{kind: tokOp, text: string(c), pos: start}, nil
+	case strings.ContainsRune("()[].,", c):
+		l.pos++
+		return token{kind: tokPunct, text: string(c), pos: start}, nil
+	case c == '"' || c == '\'':
+		return l.lexString(c)
+	case unicode.IsDigit(c) || (c == '-' && l.pos+1 < len(l.src) && unicode.IsDigit(l.src[l.pos+1])):
+		l.pos++
+		for l.pos < len(l.src) && (unicode.IsDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: string(l.src[start:l.pos]), pos: start}, nil
+	case isIdentRune(c, true):
+		for l.pos < len(l.src) && isIdentRune(l.src[l.pos], false) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: string(l.src[start:l.pos]), pos: start}, nil
+	}
+	return token{}, fmt.Errorf("unexpected %q at %d", c, start)
+}
+
+func (l *lexer) lexString(quote rune) (token, error) {
+	start := l.pos
+	l.pos++
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\\' && l.pos+1 < len(l.src):
+			l.pos++
+			switch e := l.src[l.pos]; e {
+			case 'n':
+				sb.WriteRune('\n')
+			case 't':
+				sb.WriteRune('\t')
+			default:
+				sb.WriteRune(e)
+			}
+		case c == quote:
+			l.pos++
+			return token{kind: tokString, text: sb.String(), pos: start}, nil
+		default:
+			sb.WriteRune(c)
+		}
+		l.pos++
+	}
+	return token{}, fmt.Errorf("unterminated string at %d", start)
+}
+
+func isIdentRune(c rune, first bool) bool {
+	if c == '_' || unicode.IsLetter(c) {
+		return true
+	}
+	return !first && (unicode.IsDigit(c) || c == '-')
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func (p *parser) next() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at %d", fmt.Sprintf(format, args...), p.tok.pos)
+}
+
+func (p *parser) is(kind tokenKind, text string) bool {
+	return p.tok.kind == kind && p.tok.text == text
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if !p.is(kind, text) {
+		if p.tok.kind == tokEOF {
+			return p.errorf("expected %q but the expression ended", text)
+		}
+		return p.errorf("expected %q but got %q", text, p.tok.text)
+	}
+	return p.next()
+}
+
+func (p *parser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.is(tokOp, "||") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for p.is(tokOp, "&&") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseCompare() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokOp && p.tok.text != "&&" && p.tok.text != "||" && p.tok.text != "!" {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &binary{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	if p.is(tokOp, "!") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &not{node: node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		return &literal{value: tok.text}, p.next()
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		return &literal{value: f}, p.next()
+	case tokRefOpen:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRefClose {
+			return nil, p.errorf("expected \"}\" to close \"${\"")
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return p.parsePath(node, "")
+	case tokPunct:
+		if tok.text == "(" {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(tokPunct, ")")
+		}
+	case tokIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		switch tok.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null", "nil":
+			return &literal{value: nil}, nil
+		}
+		if p.is(tokPunct, "(") {
+			return p.parseCall(tok.text)
+		}
+		return p.parsePath(&name{name: tok.text}, tok.text)
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+func (p *parser) parseCall(fn string) (Node, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	c := &call{name: fn}
+	for !p.is(tokPunct, ")") {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		if !p.is(tokPunct, ",") {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expect(tokPunct, ")")
+}
+
+// parsePath parses the .field and [index] accessors after a name.
+func (p *parser) parsePath(node Node, path string) (Node, error) {
+	for {
+		switch {
+		case p.is(tokPunct, "."):
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind != tokIdent && p.tok.kind != tokNumber {
+				return nil, p.errorf("expected a field name after \".\"")
+			}
+			path = path + "." + p.tok.text
+			node = &field{target: node, name: p.tok.text, path: path}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		case p.is(tokPunct, "["):
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			idx, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			node = &index{target: node, index: idx, path: path}
+			if err := p.expect(tokPunct, "]"); err != nil {
+				return nil, err
+			}
+		default:
+			return node, nil
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// UndefinedError is returned when an expression references a name, field or index that doesn't exist.
+type UndefinedError struct {
+	Ref string
+}
+
+func (e *UndefinedError) Error() string {
+	return fmt.Sprintf("undefined reference: %s", e.Ref)
+}
+
+// Ref is a ${...} reference in a template.
+type Ref struct {
+	Text    string  // the reference as written, like ${name:-x}
+	Expr    string  // the expression, like name
+	Default *string // the text after :-, used when the expression is undefined or empty
+}
+
+// Template renders strings with ${...} references in them, see Render.
+type Template struct {
+	Funcs map[string]Func
+	// Lenient leaves the references that can't be resolved as they are, instead of failing
+	Lenient bool
+}
+
+// Render resolves the ${...} references in src against the scope. A reference is any expression, see Eval,
+// optionally followed by :- and the text to use when it is undefined or empty, like ${arch:-armv7}. When src
+// is a single reference its value is returned as it is, so a list stays a list. Otherwise the values are
+// joined into a string. $${ is an escaped ${ and is left as ${.
+func (t *Template) Render(src string, scope Scope) (interface{}, error) {
+	parts, err := parseTemplate(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 1 && parts[0].ref != nil {
+		return t.eval(parts[0].ref, scope)
+	}
+	var sb strings.Builder
+	for _, part := range parts {
+		if part.ref == nil {
+			sb.WriteString(part.text)
+			continue
+		}
+		v, err := t.eval(part.ref, scope)
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(ToString(v))
+	}
+	return sb.String(), nil
+}
+
+func (t *Template) eval(ref *Ref, scope Scope) (interface{}, error) {
+	v, err := Eval(ref.Expr, scope, t.Funcs)
+	var undefined *UndefinedError
+	if err != nil && !errors.As(err, &undefined) {
+		return nil, fmt.Errorf("%s: %v", ref.Text, err)
+	}
+	if ref.Default != nil && (err != nil || v == nil || v == "") {
+		return *ref.Default, nil
+	}
+	if err != nil {
+		if t.Lenient {
+			return ref.Text, nil
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+// Refs returns the ${...} references in src.
+func Refs(src string) ([]Ref, error) {
+	parts, err := parseTemplate(src)
+	if err != nil {
+		return nil, err
+	}
+	var refs []Ref
+	for _, part := range parts {
+		if part.ref != nil {
+			refs = append(refs, *part.ref)
+		}
+	}
+	return refs, nil
+}
+
+// templatePart is either text, or a reference.
+type templatePart struct {
+	text string
+	ref  *Ref
+}
+
+// parseTemplate splits src into text and references. The } closing a reference is found by skipping over
+// strings and nested braces, so ${join(list, "}")} is a single reference.
+func parseTemplate(src string) ([]templatePart, error) {
+	var parts []templatePart
+	var text strings.Builder
+	for i := 0; i < len(src); {
+		if strings.HasPrefix(src[i:], "$${") {
+			text.WriteString("${")
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(src[i:], "${") {
+			text.WriteByte(src[i])
+			i++
+			continue
+		}
+		end, err := refEnd(src, i+2)
+		if err != nil {
+			return nil, err
+		}
+		if text.Len() > 0 {
+			parts = append(parts, templatePart{text: text.String()})
+			text.Reset()
+		}
+		parts = append(parts, templatePart{ref: newRef(src[i:end+1], src[i+2:end])})
+		i = end + 1
+	}
+	if text.Len() > 0 || len(parts) == 0 {
+		parts = append(parts, templatePart{text: text.String()})
+	}
+	return parts, nil
+}
+
+// refEnd returns the index of the } closing the reference whose expression starts at start.
+func refEnd(src string, start int) (int, error) {
+	depth := 0
+	var quote byte
+	for i := start; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth == 0 {
+				return i, nil
+			}
+			depth--
+		}
+	}
+	return 0, fmt.Errorf("unterminated %q", src[start-2:])
+}
+
+// newRef splits the expression of a reference from its :- default, outside of any string.
+func newRef(text, inner string) *Ref {
+	ref := &Ref{Text: text, Expr: strings.TrimSpace(inner)}
+	var quote byte
+	for i := 0; i+1 < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ':' && inner[i+1] == '-':
+			def := inner[i+2:]
+			ref.Expr = strings.TrimSpace(inner[:i])
+			ref.Default = &def
+			return ref
+		}
+	}
+	return ref
+}
@@ -0,0 +1,65 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	scope := Scope{
+		"name":  "rubix",
+		"empty": "",
+		"port":  1660,
+		"list":  []interface{}{"a", "b"},
+		"env":   map[string]interface{}{"HOME": "/root"},
+	}
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`plain`, "plain"},
+		{`${name}.service`, "rubix.service"},
+		{`${port}`, 1660},
+		{`${list}`, []interface{}{"a", "b"}},
+		{`:${port}`, ":1660"},
+		{`${missing:-armv7}`, "armv7"},
+		{`${empty:-x}-${name:-x}`, "x-rubix"},
+		{`${missing:-}`, ""},
+		{`$${name} is ${name}`, "${name} is rubix"},
+		{`${upper(name)}`, "RUBIX"},
+		{`${join(list, "}")}`, "a}b"},
+		{`${base64("a:b")}`, "YTpi"},
+		{`${env.HOME}/bin`, "/root/bin"},
+		{`${missing}`, nil},
+		{`${name`, nil},
+		{`${upper(name, name)}`, nil},
+	}
+	tmpl := &Template{Funcs: Builtins}
+	for _, tt := range tests {
+		got, err := tmpl.Render(tt.src, scope)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tt.src, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestRenderLenient(t *testing.T) {
+	tmpl := &Template{Funcs: Builtins, Lenient: true}
+	got, err := tmpl.Render(`${dir}/${missing}`, Scope{"dir": "/opt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/opt/${missing}" {
+		t.Errorf("got %v", got)
+	}
+}
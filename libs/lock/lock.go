@@ -0,0 +1,82 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// DefaultFile is the lock file, in the temp dir, used when BIOS_LOCK_FILE is not set.
+const DefaultFile = "bios.lock"
+
+// pollInterval is how often Acquire tries to take a lock that is held.
+const pollInterval = 100 * time.Millisecond
+
+// Lock is a flock on a file, so that only one holder on the host has it at a time, whatever process it is in.
+// bios build and bios serve take it to run their flows one at a time.
+type Lock struct {
+	path string
+}
+
+// New creates a Lock on the file at path, the file is created when it is first taken.
+func New(path string) *Lock {
+	return &Lock{path: path}
+}
+
+// Default creates a Lock on BIOS_LOCK_FILE, or DefaultFile in the temp dir.
+func Default() *Lock {
+	path := os.Getenv("BIOS_LOCK_FILE")
+	if path == "" {
+		path = filepath.Join(os.TempDir(), DefaultFile)
+	}
+	return New(path)
+}
+
+// Path is the lock file.
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// TryAcquire takes the lock if nobody holds it, and returns the func that releases it. ok is false when the
+// lock is held.
+func (l *Lock) TryAcquire() (release func(), ok bool, err error) {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open the lock file: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to lock %s: %v", l.path, err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, true, nil
+}
+
+// Acquire waits until it takes the lock, and returns the func that releases it. It fails when ctx is done first.
+func (l *Lock) Acquire(ctx context.Context) (func(), error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		release, ok, err := l.TryAcquire()
+		if err != nil || ok {
+			return release, err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
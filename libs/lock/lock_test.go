@@ -0,0 +1,45 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bios.lock")
+	release, err := New(path).Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another Lock on the file, like the one of another bios, doesn't get it
+	other := New(path)
+	if _, ok, err := other.TryAcquire(); ok || err != nil {
+		t.Fatalf("got the held lock: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := other.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want to give up waiting", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		release, err := other.Acquire(context.Background())
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lock wasn't taken once released")
+	}
+}
@@ -0,0 +1,135 @@
+package runs
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultFile is the file runs are stored in when BIOS_RUNS_FILE is not set.
+const DefaultFile = "runs.jsonl"
+
+// Run is the record of a single flow execution.
+type Run struct {
+	ID         string            `json:"id"`
+	File       string            `json:"file"`
+	Name       string            `json:"name,omitempty"`
+	Args       map[string]string `json:"args,omitempty"`
+	Status     string            `json:"status"`
+	Steps      interface{}       `json:"steps,omitempty"`
+	Errors     []string          `json:"errors,omitempty"`
+	StartedAt  time.Time         `json:"startedAt"`
+	FinishedAt time.Time         `json:"finishedAt"`
+	Duration   string            `json:"duration"`
+	Log        string            `json:"log,omitempty"` // the file the output of the steps was logged to
+}
+
+// Store keeps the history of flow executions.
+type Store interface {
+	Add(run *Run) error
+	List() ([]*Run, error)
+	Get(id string) (*Run, error)
+}
+
+type store struct {
+	mu   sync.Mutex
+	path string
+}
+
+// New creates a Store that appends runs to a JSONL file at path.
+func New(path string) Store {
+	return &store{path: path}
+}
+
+// Default creates a Store using BIOS_RUNS_FILE, or DefaultFile in the working dir.
+func Default() Store {
+	path := os.Getenv("BIOS_RUNS_FILE")
+	if path == "" {
+		path = DefaultFile
+	}
+	return New(path)
+}
+
+// NewID generates a random run id.
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Add appends the run to the end of the file.
+func (s *store) Add(run *Run) error {
+	line, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to encode run %s: %v", run.ID, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open runs file %s: %v", s.path, err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write run %s: %v", run.ID, err)
+	}
+	return nil
+}
+
+// List returns all the stored runs, oldest first. A run is added when it starts and again when it ends, the
+// last record of an id replaces the earlier ones. Lines that can't be decoded are skipped.
+func (s *store) List() ([]*Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open runs file %s: %v", s.path, err)
+	}
+	defer f.Close()
+
+	var out []*Run
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			continue
+		}
+		if i, ok := index[run.ID]; ok {
+			out[i] = &run
+			continue
+		}
+		index[run.ID] = len(out)
+		out = append(out, &run)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read runs file %s: %v", s.path, err)
+	}
+	return out, nil
+}
+
+// Get returns the run with the given id.
+func (s *store) Get(id string) (*Run, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range all {
+		if run.ID == id {
+			return run, nil
+		}
+	}
+	return nil, fmt.Errorf("run %s not found", id)
+}
@@ -0,0 +1,61 @@
+package runs
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "runs.jsonl"))
+	for _, file := range []string{"ctl.yaml", "git.yaml"} {
+		err := s.Add(&Run{ID: NewID(), File: file, Status: "succeeded", StartedAt: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[1].File != "git.yaml" {
+		t.Fatalf("unexpected runs: %+v", all)
+	}
+	run, err := s.Get(all[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.File != "ctl.yaml" {
+		t.Fatalf("got run for %s", run.File)
+	}
+	if _, err := s.Get("missing"); err == nil {
+		t.Fatal("expected an error for a missing run")
+	}
+}
+
+func TestStoreLastRecord(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "runs.jsonl"))
+	started := &Run{ID: NewID(), File: "ctl.yaml", Status: "running", StartedAt: time.Now()}
+	other := &Run{ID: NewID(), File: "git.yaml", Status: "succeeded", StartedAt: time.Now()}
+	finished := *started
+	finished.Status = "failed"
+	for _, run := range []*Run{started, other, &finished} {
+		if err := s.Add(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].ID != started.ID || all[0].Status != "failed" || all[1].ID != other.ID {
+		t.Fatalf("unexpected runs: %+v", all)
+	}
+	run, err := s.Get(started.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != "failed" {
+		t.Fatalf("got status %s, want the last record", run.Status)
+	}
+}
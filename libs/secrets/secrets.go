@@ -0,0 +1,198 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultFile is the secrets file used when BIOS_SECRETS_FILE is not set.
+const DefaultFile = "secrets.enc"
+
+// ErrNotFound is returned by Get for a secret that isn't in the store.
+var ErrNotFound = errors.New("secret not found")
+
+// Store keeps named secrets, encrypted with AES-GCM.
+type Store interface {
+	Set(name, value string) error
+	Get(name string) (string, error)
+	List() ([]string, error)
+}
+
+type store struct {
+	mu   sync.Mutex
+	path string
+	key  func(create bool) ([]byte, error)
+}
+
+// New creates a Store that keeps the secrets in a file at path, encrypted with the 32 byte key.
+func New(path string, key []byte) Store {
+	return &store{path: path, key: func(bool) ([]byte, error) {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("the secrets key must be 32 bytes, not %d", len(key))
+		}
+		return key, nil
+	}}
+}
+
+// Default creates a Store using BIOS_SECRETS_FILE, or DefaultFile in the working dir. The key is derived from
+// the BIOS_SECRETS_KEY passphrase, or else read from BIOS_SECRETS_KEY_FILE, by default the secrets file with
+// a .key extension, which is created with a random key when the first secret is set.
+func Default() Store {
+	path := os.Getenv("BIOS_SECRETS_FILE")
+	if path == "" {
+		path = DefaultFile
+	}
+	keyFile := os.Getenv("BIOS_SECRETS_KEY_FILE")
+	if keyFile == "" {
+		keyFile = strings.TrimSuffix(path, filepath.Ext(path)) + ".key"
+	}
+	return &store{path: path, key: func(create bool) ([]byte, error) {
+		if passphrase := os.Getenv("BIOS_SECRETS_KEY"); passphrase != "" {
+			key := sha256.Sum256([]byte(passphrase))
+			return key[:], nil
+		}
+		return readKeyFile(keyFile, create)
+	}}
+}
+
+// readKeyFile reads the hex encoded key from path, creating it when it doesn't exist and create is set.
+func readKeyFile(path string, create bool) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) && create {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write secrets key %s: %v", path, err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets key %s, set BIOS_SECRETS_KEY or BIOS_SECRETS_KEY_FILE: %v", path, err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid secrets key %s, expected 32 hex encoded bytes", path)
+	}
+	return key, nil
+}
+
+// Set encrypts the value and saves it under the name, replacing any previous value.
+func (s *store) Set(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("a secret needs a name")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	key, err := s.key(true)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// the name is authenticated with the value, so values can't be swapped between names
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(name))
+	all[name] = base64.StdEncoding.EncodeToString(sealed)
+	b, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path, append(b, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write secrets file %s: %v", s.path, err)
+	}
+	return nil
+}
+
+// Get decrypts the secret with the name, it returns ErrNotFound when there is none.
+func (s *store) Get(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.read()
+	if err != nil {
+		return "", err
+	}
+	encoded, ok := all[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	key, err := s.key(false)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("secret %s is corrupt", name)
+	}
+	value, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret %s, is it the right key?", name)
+	}
+	return string(value), nil
+}
+
+// List returns the names of the secrets, sorted. The values are not decrypted.
+func (s *store) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// read returns the encrypted secrets of the file by name, none when the file doesn't exist yet.
+func (s *store) read() (map[string]string, error) {
+	all := make(map[string]string)
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file %s: %v", s.path, err)
+	}
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %v", s.path, err)
+	}
+	return all, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
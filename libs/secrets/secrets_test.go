@@ -0,0 +1,67 @@
+package secrets
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	key := bytes.Repeat([]byte{7}, 32)
+	s := New(path, key)
+	if _, err := s.Get("token"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	for name, value := range map[string]string{"token": "ghp_abc", "password": "hunter2"} {
+		if err := s.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	value, err := s.Get("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "ghp_abc" {
+		t.Fatalf("got %q", value)
+	}
+	names, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "password" || names[1] != "token" {
+		t.Fatalf("unexpected names: %v", names)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("ghp_abc")) {
+		t.Fatal("the secrets file has the value in plain text")
+	}
+	if _, err := New(path, bytes.Repeat([]byte{8}, 32)).Get("token"); err == nil {
+		t.Fatal("expected an error with the wrong key")
+	}
+}
+
+func TestDefaultKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("BIOS_SECRETS_FILE", filepath.Join(dir, "secrets.enc"))
+	t.Setenv("BIOS_SECRETS_KEY", "")
+	t.Setenv("BIOS_SECRETS_KEY_FILE", "")
+	if err := Default().Set("token", "ghp_abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "secrets.key")); err != nil {
+		t.Fatalf("expected the key file to be created: %v", err)
+	}
+	value, err := Default().Get("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "ghp_abc" {
+		t.Fatalf("got %q", value)
+	}
+}
@@ -1,116 +1,301 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	commander "github.com/NubeIO/bios-cli/cmd"
+	"github.com/NubeIO/bios-cli/libs/events"
+	"github.com/NubeIO/bios-cli/libs/lock"
+	"github.com/NubeIO/bios-cli/libs/runs"
+	"github.com/NubeIO/bios-cli/libs/secrets"
+	"github.com/NubeIO/bios-cli/server"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
+	if len(os.Args) < 2 {
+		log.Fatalf("usage: bios build <file.yaml> [key=value...] | bios help <file.yaml> | bios validate <file.yaml>... | bios schema | bios secrets set|get|list | bios serve [-addr :1662] [-dir ./] [-max-jobs 1]")
+	}
+	if os.Args[1] == "server" || os.Args[1] == "serve" {
+		runServer(os.Args[2:])
+		return
+	}
+	if os.Args[1] == "runs" {
+		runRuns(os.Args[2:])
+		return
+	}
+	if os.Args[1] == "validate" || os.Args[1] == "lint" {
+		runValidate(os.Args[2:])
+		return
+	}
+	if os.Args[1] == "secrets" {
+		runSecrets(os.Args[2:])
+		return
+	}
+	if os.Args[1] == "help" {
+		runHelp(os.Args[2:])
+		return
+	}
+	if os.Args[1] == "schema" {
+		out, _ := json.MarshalIndent(commander.NewBuildTool().JSONSchema(), "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	if len(os.Args) < 3 {
+		log.Fatalf("usage: bios build <file.yaml> [--stream-addr :1663] [--dry-run] [key=value...]")
+	}
+	rawFlags, rawArgs := splitFlags(os.Args[2:], "dry-run")
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	streamAddr := fs.String("stream-addr", "", "serve the output of the steps as Server-Sent Events on this address while the flow runs")
+	dryRun := fs.Bool("dry-run", false, "print the plan of every step, with the vars and args resolved, without running them")
+	fs.Parse(rawFlags)
+	if len(rawArgs) < 1 {
+		log.Fatalf("usage: bios build <file.yaml> [--stream-addr :1663] [--dry-run] [key=value...]")
+	}
+
 	bt := commander.NewBuildTool()
-	var resp []*response
-	command := os.Args[2]
+	bt.Runs = runs.Default()
+	bt.LogDir = commander.DefaultLogDir()
+	bt.DryRun = *dryRun
+	bt.Secrets = secrets.Default()
+	bt.ArgFiles = true
+	command := rawArgs[0]
 	if command == "listCommands" {
-		_, err := bt.ExecuteStep(commander.BuildStep{Name: "listCommands", Cmd: "listCommands", Params: nil})
+		_, err := bt.ExecuteStep(context.Background(), commander.BuildStep{Name: "listCommands", Cmd: "listCommands", Params: nil})
 		if err != nil {
 			log.Fatalf("Error executing command listCommands: %v", err)
 		}
 		return
 	}
 
-	buildYAML, err := bt.LoadBuildYAML(command)
+	stopStream := func() {}
+	if *streamAddr != "" {
+		stopStream = startStream(bt, *streamAddr)
+	}
+	// stop the running step on ctrl+c or when the service is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	args := commander.ParseArgs(rawArgs[1:])
+	release := func() {}
+	if !*dryRun {
+		// one flow at a time on the host, like the jobs of bios serve
+		release = lockHost(ctx)
+	}
+	resp := bt.RunFile(ctx, command, args)
+	release()
+	stop()
+	stopStream()
+	dump(resp)
+	// exit with 1 when the flow failed, so that a script or a service running bios build sees it
+	if commander.Failure(resp) != nil {
+		os.Exit(1)
+	}
+}
+
+// lockHost waits for the flow running on the host, by another bios build or bios serve, to finish. It exits
+// when ctx is done first.
+func lockHost(ctx context.Context) func() {
+	host := lock.Default()
+	release, ok, err := host.TryAcquire()
+	if err == nil && !ok {
+		log.Printf("waiting for the flow running on this host to finish (%s)", host.Path())
+		release, err = host.Acquire(ctx)
+	}
 	if err != nil {
-		out := &response{
-			File:  command,
-			Error: err.Error(),
-		}
-		resp = append(resp, out)
-		dump(resp)
-		return
+		log.Fatalf("failed to lock the host: %v", err)
 	}
+	return release
+}
 
-	args := parseArgs(os.Args[3:])
+// startStream serves the events of bt on addr until the returned func is called.
+func startStream(bt *commander.BuildTool, addr string) func() {
+	bt.Events = events.NewBroker()
+	mux := http.NewServeMux()
+	mux.Handle("/events", bt.Events)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("stream server error: %v", err)
+		}
+	}()
+	log.Printf("streaming step output on http://%s/events", addr)
+	return func() {
+		bt.Events.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}
+}
 
-	for i, step := range buildYAML.Steps {
-		out := &response{
-			Name:      step.Name,
-			Cmd:       step.Cmd,
-			StepCount: i,
-		}
-		params := replaceParams(step.Params, buildYAML.Vars, args)
-		ret, err := bt.ExecuteStep(commander.BuildStep{Name: step.Name, Cmd: step.Cmd, Params: params})
-		if err != nil {
-			out.Error = err.Error()
-		} else {
-			out.Response = ret
-			resp = append(resp, out)
+// splitFlags splits the raw args into the --flags and the key=value args. A flag without "=" takes the next
+// arg as its value unless that looks like a key=value arg or another flag, or it is one of the bool flags.
+func splitFlags(raw []string, bools ...string) (flags []string, args []string) {
+	for i := 0; i < len(raw); i++ {
+		if !strings.HasPrefix(raw[i], "-") {
+			args = append(args, raw[i])
+			continue
+		}
+		flags = append(flags, raw[i])
+		isBool := false
+		for _, b := range bools {
+			isBool = isBool || strings.TrimLeft(raw[i], "-") == b
+		}
+		if !isBool && !strings.Contains(raw[i], "=") && i+1 < len(raw) && !strings.Contains(raw[i+1], "=") && !strings.HasPrefix(raw[i+1], "-") {
+			flags = append(flags, raw[i+1])
+			i++
 		}
 	}
-	dump(resp)
+	return flags, args
 }
 
-func dump(resp any) {
-	marshal, err := json.Marshal(resp)
+func runServer(rawArgs []string) {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	addr := fs.String("addr", ":1662", "address to listen on")
+	dir := fs.String("dir", "./", "dir to load the flow files from")
+	maxJobs := fs.Int("max-jobs", 1, "max number of background jobs to run at the same time")
+	fs.Parse(rawArgs)
+
+	err := server.New(*addr, *dir, *maxJobs, runs.Default()).ListenAndServe()
 	if err != nil {
-		return
+		log.Fatalf("server error: %v", err)
 	}
-	fmt.Println(string(marshal))
 }
 
-type response struct {
-	File      string      `json:"file,omitempty"`
-	Name      string      `json:"name,omitempty"`
-	Cmd       string      `json:"cmd,omitempty"`
-	StepCount int         `json:"stepCount,omitempty"`
-	Response  interface{} `json:"response,omitempty"`
-	Error     string      `json:"error,omitempty"`
+// runSummary is a run as shown by `runs list`, without the step results.
+type runSummary struct {
+	ID        string    `json:"id"`
+	File      string    `json:"file"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"startedAt"`
+	Duration  string    `json:"duration"`
+	Errors    int       `json:"errors,omitempty"`
 }
 
-func parseArgs(rawArgs []string) map[string]string {
-	args := make(map[string]string)
-	for _, arg := range rawArgs {
-		parts := strings.SplitN(arg, "=", 2)
-		if len(parts) == 2 {
-			args[parts[0]] = parts[1]
+func runRuns(rawArgs []string) {
+	store := runs.Default()
+	if len(rawArgs) == 0 {
+		log.Fatalf("usage: bios runs list | bios runs show <id>")
+	}
+	switch rawArgs[0] {
+	case "list":
+		all, err := store.List()
+		if err != nil {
+			log.Fatalf("Error listing runs: %v", err)
+		}
+		out := make([]runSummary, 0, len(all))
+		for _, run := range all {
+			out = append(out, runSummary{
+				ID:        run.ID,
+				File:      run.File,
+				Status:    run.Status,
+				StartedAt: run.StartedAt,
+				Duration:  run.Duration,
+				Errors:    len(run.Errors),
+			})
 		}
+		dump(out)
+	case "show":
+		if len(rawArgs) < 2 {
+			log.Fatalf("usage: bios runs show <id>")
+		}
+		run, err := store.Get(rawArgs[1])
+		if err != nil {
+			log.Fatalf("Error getting run: %v", err)
+		}
+		dump(run)
+	default:
+		log.Fatalf("unknown runs command: %s, try: list or show", rawArgs[0])
 	}
-	return args
 }
 
-func replaceParams(params interface{}, vars []commander.Variable, args map[string]string) interface{} {
-	switch p := params.(type) {
-	case string:
-		return replaceVarsAndArgs(p, vars, args)
-	case []interface{}:
-		var replacedParams []interface{}
-		for _, param := range p {
-			replacedParams = append(replacedParams, replaceVarsAndArgs(fmt.Sprintf("%v", param), vars, args))
-		}
-		return replacedParams
-	case map[string]interface{}:
-		replacedParams := make(map[string]interface{})
-		for key, param := range p {
-			replacedParams[key] = replaceVarsAndArgs(fmt.Sprintf("%v", param), vars, args)
-		}
-		return replacedParams
-	}
-	return params
+// runSecrets manages the encrypted secrets store. The value of set is read from stdin when it isn't passed,
+// so it doesn't show in the process list or the shell history.
+func runSecrets(rawArgs []string) {
+	store := secrets.Default()
+	if len(rawArgs) == 0 {
+		log.Fatalf("usage: bios secrets set <name> [value] | bios secrets get <name> | bios secrets list")
+	}
+	switch rawArgs[0] {
+	case "set":
+		if len(rawArgs) < 2 {
+			log.Fatalf("usage: bios secrets set <name> [value]")
+		}
+		var value string
+		if len(rawArgs) > 2 {
+			value = rawArgs[2]
+		} else {
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("Error reading the secret from stdin: %v", err)
+			}
+			value = strings.TrimRight(string(b), "\r\n")
+		}
+		if err := store.Set(rawArgs[1], value); err != nil {
+			log.Fatalf("Error setting secret: %v", err)
+		}
+	case "get":
+		if len(rawArgs) < 2 {
+			log.Fatalf("usage: bios secrets get <name>")
+		}
+		value, err := store.Get(rawArgs[1])
+		if err != nil {
+			log.Fatalf("Error getting secret %s: %v", rawArgs[1], err)
+		}
+		fmt.Println(value)
+	case "list":
+		names, err := store.List()
+		if err != nil {
+			log.Fatalf("Error listing secrets: %v", err)
+		}
+		dump(names)
+	default:
+		log.Fatalf("unknown secrets command: %s, try: set, get or list", rawArgs[0])
+	}
 }
 
-func replaceVarsAndArgs(param string, vars []commander.Variable, args map[string]string) string {
-	result := param
-	for key, val := range args {
-		result = strings.ReplaceAll(result, fmt.Sprintf("${%s}", key), val)
-	}
-	for _, v := range vars {
-		if val, ok := args[v.Name]; ok {
-			result = strings.ReplaceAll(result, fmt.Sprintf("${%s}", v.Name), val)
-		} else {
-			result = strings.ReplaceAll(result, fmt.Sprintf("${%s}", v.Name), v.Value)
+// runHelp prints what the flow files do and the args they take.
+func runHelp(files []string) {
+	if len(files) == 0 {
+		log.Fatalf("usage: bios help <file.yaml>...")
+	}
+	bt := commander.NewBuildTool()
+	for _, file := range files {
+		buildYAML, err := bt.LoadBuildYAML(file)
+		if err != nil {
+			log.Fatalf("Error loading %s: %v", file, err)
 		}
+		fmt.Print(buildYAML.Help())
+	}
+}
+
+// runValidate checks the flow files and prints their issues, it exits with 1 if any of them has errors.
+func runValidate(files []string) {
+	if len(files) == 0 {
+		log.Fatalf("usage: bios validate <file.yaml>...")
+	}
+	bt := commander.NewBuildTool()
+	issues := make([]commander.Issue, 0)
+	for _, file := range files {
+		issues = append(issues, bt.Validate(file)...)
 	}
-	return result
+	dump(issues)
+	if commander.HasErrors(issues) {
+		os.Exit(1)
+	}
+}
+
+func dump(resp any) {
+	marshal, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	fmt.Println(string(marshal))
 }
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	commander "github.com/NubeIO/bios-cli/cmd"
+	"github.com/NubeIO/bios-cli/libs/lock"
+	"github.com/NubeIO/bios-cli/libs/runs"
+	"github.com/NubeIO/bios-cli/libs/secrets"
+	"sync"
+	"time"
+)
+
+// maxJobHistory is the number of finished jobs kept in memory.
+const maxJobHistory = 200
+
+// Job is a flow file queued to run in the background.
+type Job struct {
+	ID         string               `json:"id"`
+	File       string               `json:"file"`
+	Args       map[string]string    `json:"args,omitempty"` // with the values of the secret args redacted
+	Status     string               `json:"status"`
+	Error      string               `json:"error,omitempty"`
+	Steps      []commander.Response `json:"steps"`
+	CreatedAt  time.Time            `json:"createdAt"`
+	StartedAt  *time.Time           `json:"startedAt,omitempty"`
+	FinishedAt *time.Time           `json:"finishedAt,omitempty"`
+}
+
+// Jobs queues flow files and runs them in the background, at most maxParallel at a time.
+type Jobs struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+	slots chan struct{}
+	host  *lock.Lock // taken by every flow when they run one at a time, see NewJobs
+	runs  runs.Store
+}
+
+// NewJobs creates a job queue running at most maxParallel jobs at a time, recording each run to store. With
+// one at a time, the default, the flows also take the lock of the host, lock.Default, so that they don't run
+// at the same time as a bios build or the flows of another bios serve.
+func NewJobs(maxParallel int, store runs.Store) *Jobs {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	j := &Jobs{
+		jobs:  make(map[string]*Job),
+		slots: make(chan struct{}, maxParallel),
+		runs:  store,
+	}
+	if maxParallel == 1 {
+		j.host = lock.Default()
+	}
+	return j
+}
+
+// Submit queues the flow file to run and returns the new job straight away.
+func (j *Jobs) Submit(file, path string, args map[string]string) (*Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		ID:        id,
+		File:      file,
+		Status:    commander.StatusPending,
+		CreatedAt: time.Now(),
+	}
+	bt := commander.NewBuildTool()
+	bt.Runs = j.runs
+	bt.LogDir = commander.DefaultLogDir()
+	bt.Secrets = secrets.Default()
+	buildYAML, err := bt.LoadBuildYAML(path)
+	if err != nil {
+		return nil, err
+	}
+	job.Args = buildYAML.RedactArgs(args)
+	for i, step := range buildYAML.Steps {
+		job.Steps = append(job.Steps, commander.Response{Name: step.Name, Cmd: step.Cmd, StepCount: i, Status: commander.StatusPending})
+	}
+
+	j.mu.Lock()
+	j.jobs[id] = job
+	j.order = append(j.order, id)
+	j.prune()
+	j.mu.Unlock()
+
+	go j.run(job, bt, buildYAML, args)
+	return j.Get(id), nil
+}
+
+// acquire waits for a slot to run a flow in, so that the flows of /run and of the jobs don't run at the same
+// time, more than maxParallel at least, then for the lock of the host when there is one. It returns the func
+// that frees them, and fails when ctx is done first.
+func (j *Jobs) acquire(ctx context.Context) (func(), error) {
+	select {
+	case j.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// the slot and ctx can be done at the same time, don't start a flow nobody waits for
+	if err := ctx.Err(); err != nil {
+		<-j.slots
+		return nil, err
+	}
+	if j.host == nil {
+		return func() { <-j.slots }, nil
+	}
+	unlock, err := j.host.Acquire(ctx)
+	if err != nil {
+		<-j.slots
+		return nil, err
+	}
+	return func() {
+		unlock()
+		<-j.slots
+	}, nil
+}
+
+func (j *Jobs) run(job *Job, bt *commander.BuildTool, buildYAML *commander.BuildYAML, args map[string]string) {
+	release, err := j.acquire(context.Background())
+	if err != nil {
+		j.update(job, func() {
+			now := time.Now()
+			job.FinishedAt = &now
+			job.Status = commander.StatusFailed
+			job.Error = err.Error()
+		})
+		return
+	}
+	defer release()
+
+	j.update(job, func() {
+		now := time.Now()
+		job.Status = commander.StatusRunning
+		job.StartedAt = &now
+	})
+	bt.OnStep = func(resp *commander.Response) {
+		j.update(job, func() {
+			// rollback and finally steps are only known once they run, and are numbered after the steps
+			if resp.StepCount < len(job.Steps) {
+				job.Steps[resp.StepCount] = *resp
+			} else {
+				job.Steps = append(job.Steps, *resp)
+			}
+		})
+	}
+	resp := bt.RunFlow(context.Background(), buildYAML, args)
+
+	j.update(job, func() {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Status = commander.StatusSucceeded
+		if failed := commander.Failure(resp); failed != nil {
+			job.Status = commander.StatusFailed
+			job.Error = fmt.Sprintf("step %d (%s) failed: %s", failed.StepCount, failed.Name, failed.Error)
+			if failed.Cmd == "" {
+				// the flow failed before any step ran, like on a missing arg, so none of the steps will
+				job.Error = failed.Error
+				for i := range job.Steps {
+					job.Steps[i].Status = commander.StatusSkipped
+				}
+			}
+		}
+	})
+}
+
+// Get returns a copy of the job with the given id, or nil if there is none.
+func (j *Jobs) Get(id string) *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return nil
+	}
+	return job.copy()
+}
+
+// List returns a copy of all the jobs, oldest first.
+func (j *Jobs) List() []*Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := make([]*Job, 0, len(j.order))
+	for _, id := range j.order {
+		out = append(out, j.jobs[id].copy())
+	}
+	return out
+}
+
+func (j *Jobs) update(job *Job, fn func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn()
+}
+
+// prune drops the oldest finished jobs once there are more than maxJobHistory, must be called with the lock held.
+func (j *Jobs) prune() {
+	for len(j.order) > maxJobHistory {
+		dropped := false
+		for i, id := range j.order {
+			if j.jobs[id].FinishedAt != nil {
+				delete(j.jobs, id)
+				j.order = append(j.order[:i], j.order[i+1:]...)
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			return
+		}
+	}
+}
+
+func (job *Job) copy() *Job {
+	out := *job
+	out.Steps = append([]commander.Response(nil), job.Steps...)
+	return &out
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
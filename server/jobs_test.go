@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	commander "github.com/NubeIO/bios-cli/cmd"
+	"github.com/NubeIO/bios-cli/libs/lock"
+	"github.com/NubeIO/bios-cli/libs/runs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitFlow logs when it starts and ends to ${log}, and waits for the file ${gate} in between.
+const waitFlow = `
+args: [name, gate, log]
+steps:
+  - name: wait
+    cmd: bash
+    params: 'echo start ${name} >> ${log}; while [ ! -e ${gate} ]; do sleep 0.01; done; echo end ${name} >> ${log}'
+`
+
+const failFlow = `
+steps:
+  - {name: fail, cmd: bash, params: "exit 3"}
+`
+
+const argFlow = `
+args: [{name: tag, required: true}]
+steps:
+  - {name: build, cmd: bash, params: "echo ${tag}"}
+  - {name: test, cmd: bash, params: "true"}
+`
+
+// testServer serves the test flows with at most maxJobs jobs at a time, it returns the server and a temp dir
+// for the gates and the log of the flows.
+func testServer(t *testing.T, maxJobs int) (*httptest.Server, string) {
+	t.Helper()
+	tmp := t.TempDir()
+	t.Setenv("BIOS_LOGS_DIR", filepath.Join(tmp, "logs"))
+	t.Setenv("BIOS_SECRETS_FILE", filepath.Join(tmp, "secrets.json"))
+	t.Setenv("BIOS_LOCK_FILE", filepath.Join(tmp, "bios.lock"))
+	dir := t.TempDir()
+	for name, content := range map[string]string{"wait.yaml": waitFlow, "fail.yaml": failFlow, "arg.yaml": argFlow} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(New("", dir, maxJobs, runs.New(filepath.Join(tmp, "runs.jsonl"))).Handler())
+	t.Cleanup(srv.Close)
+	return srv, tmp
+}
+
+func post(t *testing.T, url string, body RunRequest, out interface{}) int {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+func getJob(t *testing.T, srv *httptest.Server, id string) (*Job, int) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/jobs/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var job Job
+	json.NewDecoder(resp.Body).Decode(&job)
+	return &job, resp.StatusCode
+}
+
+// waitStatus polls the job until it has the status.
+func waitStatus(t *testing.T, srv *httptest.Server, id, status string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		job, _ := getJob(t, srv, id)
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitArgs(tmp, name string) map[string]string {
+	return map[string]string{"name": name, "gate": filepath.Join(tmp, "gate-"+name), "log": filepath.Join(tmp, "log")}
+}
+
+func open(t *testing.T, tmp, name string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(tmp, "gate-"+name), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobTransitions(t *testing.T) {
+	srv, tmp := testServer(t, 1)
+
+	var first, second Job
+	if status := post(t, srv.URL+"/jobs", RunRequest{File: "wait.yaml", Args: waitArgs(tmp, "first")}, &first); status != http.StatusAccepted {
+		t.Fatalf("got status %d", status)
+	}
+	waitStatus(t, srv, first.ID, commander.StatusRunning)
+	// the second job is queued until the first is done
+	post(t, srv.URL+"/jobs", RunRequest{File: "wait.yaml", Args: waitArgs(tmp, "second")}, &second)
+	if job, _ := getJob(t, srv, second.ID); job.Status != commander.StatusPending || job.StartedAt != nil ||
+		len(job.Steps) != 1 || job.Steps[0].Status != commander.StatusPending {
+		t.Errorf("got the second job %+v", job)
+	}
+
+	open(t, tmp, "first")
+	job := waitStatus(t, srv, first.ID, commander.StatusSucceeded)
+	if job.StartedAt == nil || job.FinishedAt == nil || job.Steps[0].Status != commander.StatusSucceeded {
+		t.Errorf("got the first job %+v", job)
+	}
+	waitStatus(t, srv, second.ID, commander.StatusRunning)
+	open(t, tmp, "second")
+	waitStatus(t, srv, second.ID, commander.StatusSucceeded)
+
+	var failed Job
+	post(t, srv.URL+"/jobs", RunRequest{File: "fail.yaml"}, &failed)
+	job = waitStatus(t, srv, failed.ID, commander.StatusFailed)
+	if !strings.HasPrefix(job.Error, "step 0 (fail) failed: ") || job.Steps[0].Status != commander.StatusFailed {
+		t.Errorf("got the failed job %+v", job)
+	}
+
+	var jobs []Job
+	resp, err := http.Get(srv.URL + "/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(&jobs)
+	if len(jobs) != 3 || jobs[0].ID != first.ID || jobs[2].ID != failed.ID {
+		t.Errorf("got jobs %+v", jobs)
+	}
+}
+
+func TestJobFlowError(t *testing.T) {
+	srv, _ := testServer(t, 1)
+	var job Job
+	post(t, srv.URL+"/jobs", RunRequest{File: "arg.yaml"}, &job)
+	got := waitStatus(t, srv, job.ID, commander.StatusFailed)
+	if got.Error != "flow: invalid args: missing required arg tag" {
+		t.Errorf("got the error %q", got.Error)
+	}
+	if len(got.Steps) != 2 || got.Steps[0].Status != commander.StatusSkipped || got.Steps[1].Status != commander.StatusSkipped {
+		t.Errorf("got the steps %+v", got.Steps)
+	}
+}
+
+func TestJobErrors(t *testing.T) {
+	srv, _ := testServer(t, 1)
+	if _, status := getJob(t, srv, "missing"); status != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown job", status)
+	}
+	for _, file := range []string{"", "../wait.yaml", "/etc/passwd", "missing.yaml"} {
+		var body map[string]string
+		if status := post(t, srv.URL+"/jobs", RunRequest{File: file}, &body); status != http.StatusBadRequest || body["error"] == "" {
+			t.Errorf("%q: got status %d with %v", file, status, body)
+		}
+	}
+}
+
+// TestRunWaitsForJobs checks that POST /run takes a job slot, so that its flow never runs at the same time as a
+// job, the flows log when they start and end and must not overlap.
+func TestRunWaitsForJobs(t *testing.T) {
+	srv, tmp := testServer(t, 1)
+	var running, queued Job
+	post(t, srv.URL+"/jobs", RunRequest{File: "wait.yaml", Args: waitArgs(tmp, "running")}, &running)
+	waitStatus(t, srv, running.ID, commander.StatusRunning)
+	post(t, srv.URL+"/jobs", RunRequest{File: "wait.yaml", Args: waitArgs(tmp, "queued")}, &queued)
+
+	done := make(chan []commander.Response)
+	go func() {
+		var resp []commander.Response
+		post(t, srv.URL+"/run", RunRequest{File: "wait.yaml", Args: waitArgs(tmp, "run")}, &resp)
+		done <- resp
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if b, _ := os.ReadFile(filepath.Join(tmp, "log")); string(b) != "start running\n" {
+		t.Errorf("got the log %q while the first job runs", b)
+	}
+	for _, name := range []string{"running", "queued", "run"} {
+		open(t, tmp, name)
+	}
+	var resp []commander.Response
+	select {
+	case resp = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("POST /run didn't return")
+	}
+	if len(resp) != 1 || resp[0].Status != commander.StatusSucceeded {
+		t.Errorf("got %+v", resp)
+	}
+	waitStatus(t, srv, queued.ID, commander.StatusSucceeded)
+
+	b, err := os.ReadFile(filepath.Join(tmp, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("got the log %q", lines)
+	}
+	for i := 0; i < len(lines); i += 2 {
+		name := strings.TrimPrefix(lines[i], "start ")
+		if lines[i+1] != "end "+name {
+			t.Errorf("the flows overlap: %q", lines)
+		}
+	}
+}
+
+func TestRunGivesUp(t *testing.T) {
+	srv, tmp := testServer(t, 1)
+	var running Job
+	post(t, srv.URL+"/jobs", RunRequest{File: "wait.yaml", Args: waitArgs(tmp, "running")}, &running)
+	waitStatus(t, srv, running.ID, commander.StatusRunning)
+	defer open(t, tmp, "running")
+	// lets the flow of /run end before the server closes, should it run by mistake
+	t.Cleanup(func() { open(t, tmp, "run") })
+
+	// the client goes away while /run waits for the job
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	b, _ := json.Marshal(RunRequest{File: "wait.yaml", Args: waitArgs(tmp, "run")})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/run", bytes.NewReader(b))
+	if _, err := http.DefaultClient.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v", err)
+	}
+	// give the server the time to see that the client went away
+	time.Sleep(100 * time.Millisecond)
+	open(t, tmp, "running")
+	waitStatus(t, srv, running.ID, commander.StatusSucceeded)
+	if b, _ := os.ReadFile(filepath.Join(tmp, "log")); string(b) != "start running\nend running\n" {
+		t.Errorf("the flow of /run ran after its client went away: %q", b)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	t.Setenv("BIOS_LOCK_FILE", filepath.Join(t.TempDir(), "bios.lock"))
+	for _, maxParallel := range []int{0, 1, 3} {
+		t.Run(fmt.Sprint(maxParallel), func(t *testing.T) {
+			j := NewJobs(maxParallel, nil)
+			want := maxParallel
+			if want < 1 {
+				want = 1
+			}
+			var releases []func()
+			for i := 0; i < want; i++ {
+				release, err := j.acquire(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				releases = append(releases, release)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if _, err := j.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("got %v, want no slot left", err)
+			}
+			for _, release := range releases {
+				release()
+			}
+			release, err := j.acquire(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			release()
+		})
+	}
+}
+
+// TestJobsWaitForHost checks that jobs run one at a time wait for the lock of the host, held here like by a
+// bios build, and that jobs run in parallel don't take it.
+func TestJobsWaitForHost(t *testing.T) {
+	srv, tmp := testServer(t, 1)
+	host, err := lock.Default().Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job Job
+	post(t, srv.URL+"/jobs", RunRequest{File: "wait.yaml", Args: waitArgs(tmp, "job")}, &job)
+	open(t, tmp, "job")
+	time.Sleep(300 * time.Millisecond)
+	if got, _ := getJob(t, srv, job.ID); got.Status != commander.StatusPending {
+		t.Errorf("the job is %s while the host is locked", got.Status)
+	}
+	host()
+	waitStatus(t, srv, job.ID, commander.StatusSucceeded)
+
+	parallel, _ := testServer(t, 2)
+	host, err = lock.Default().Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host()
+	post(t, parallel.URL+"/jobs", RunRequest{File: "wait.yaml", Args: waitArgs(tmp, "job")}, &job)
+	waitStatus(t, parallel, job.ID, commander.StatusSucceeded)
+}
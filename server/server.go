@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	commander "github.com/NubeIO/bios-cli/cmd"
+	"github.com/NubeIO/bios-cli/libs/runs"
+	"github.com/NubeIO/bios-cli/libs/secrets"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// RunRequest is the body of a request to run a flow file.
+type RunRequest struct {
+	File   string            `json:"file"`
+	Args   map[string]string `json:"args"`
+	DryRun bool              `json:"dryRun"` // POST /run only returns the plan of the steps, see commander.BuildTool.DryRun
+}
+
+// Server exposes the flow files over a REST API.
+type Server struct {
+	Addr string
+	Dir  string // flow files are resolved relative to this dir
+	Jobs *Jobs
+	Runs runs.Store // every flow run by the server is recorded here
+}
+
+// New creates a new Server listening on addr and loading flow files from dir, running at most maxJobs background jobs at a time.
+func New(addr, dir string, maxJobs int, store runs.Store) *Server {
+	return &Server{
+		Addr: addr,
+		Dir:  dir,
+		Jobs: NewJobs(maxJobs, store),
+		Runs: store,
+	}
+}
+
+// Handler returns the http.Handler with all the routes of the server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", s.handleRun)
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+	return mux
+}
+
+// ListenAndServe starts the server and blocks until it fails.
+func (s *Server) ListenAndServe() error {
+	log.Printf("bios server listening on %s", s.Addr)
+	return http.ListenAndServe(s.Addr, s.Handler())
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	var body RunRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+	file, err := s.resolveFile(body.File)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	bt := commander.NewBuildTool()
+	bt.Runs = s.Runs
+	bt.LogDir = commander.DefaultLogDir()
+	bt.Secrets = secrets.Default()
+	bt.DryRun = body.DryRun
+	buildYAML, err := bt.LoadBuildYAML(file)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, []*commander.Response{{File: body.File, Error: err.Error()}})
+		return
+	}
+	if !body.DryRun {
+		// wait for the running jobs and the flows of the host, unless the client goes away first
+		release, err := s.Jobs.acquire(r.Context())
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("gave up waiting for the running flows: %v", err))
+			return
+		}
+		defer release()
+	}
+	// once started, the flow keeps going if the client goes away, a half done install is worse than a lost response
+	writeJSON(w, http.StatusOK, bt.RunFlow(context.Background(), buildYAML, body.Args))
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Jobs.List())
+	case http.MethodPost:
+		var body RunRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+		file, err := s.resolveFile(body.File)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		job, err := s.Jobs.Submit(body.File, file, body.Args)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	job := s.Jobs.Get(id)
+	if job == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// resolveFile makes sure the requested flow file is inside the server dir.
+func (s *Server) resolveFile(file string) (string, error) {
+	if file == "" {
+		return "", fmt.Errorf("file is required")
+	}
+	if filepath.IsAbs(file) || !filepath.IsLocal(file) {
+		return "", fmt.Errorf("file %s must be a relative path inside the flows dir", file)
+	}
+	return filepath.Join(s.Dir, file), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}